@@ -0,0 +1,98 @@
+package markov
+
+// Backoff makes a chain train every ngram order from 1 to n together, so it
+// can fall back to shorter prefixes when a prefix is unknown. Generation then
+// keeps going after reaching an unknown prefix, selecting the next symbol from
+// the longest suffix of the prefix the chain knows, and the probability methods
+// answer for any prefix instead of returning an error. It's set with
+// WithBackoff. Interpolation is a Backoff too, which mixes every order for the
+// probabilities instead of falling back.
+type Backoff interface {
+	// probability returns the probability of the word following the key,
+	// backing off to its suffixes. lookup returns the candidates of any key
+	// of n-1 symbols or less.
+	probability(lookup func(key string) (*candidates, bool), stats *ngramStats, key string, word uint32) float64
+}
+
+// StupidBackoff returns a backoff which uses the relative frequency of the word
+// after the longest suffix of the prefix it followed, multiplied by alpha for
+// every symbol dropped from the prefix. It's cheap and works well for large
+// corpora, but its scores are not normalised probabilities. alpha must be in
+// (0, 1], and 0.4 usually works well.
+func StupidBackoff(alpha float64) Backoff {
+	return stupidBackoff{alpha: alpha}
+}
+
+// KatzBackoff returns a Katz backoff. The frequencies below 5 are discounted
+// with Good-Turing, estimated for every order, and the probability mass left
+// is given to the words which never followed the prefix, in proportion to
+// their backed off probability.
+func KatzBackoff() Backoff {
+	return katzBackoff{}
+}
+
+type stupidBackoff struct {
+	alpha float64
+}
+
+func (s stupidBackoff) probability(lookup func(key string) (*candidates, bool), stats *ngramStats, key string, word uint32) float64 {
+	var weight = 1.0
+	for {
+		if candidates, exists := lookup(key); exists {
+			if probability := candidates.probability(word, stats.minCount); probability > 0 {
+				return weight * probability
+			}
+		}
+
+		// the word is unknown, even as a unigram
+		if len(key) == 0 {
+			return 0
+		}
+
+		key = key[idSize:]
+		weight *= s.alpha
+	}
+}
+
+type katzBackoff struct{}
+
+func (b katzBackoff) probability(lookup func(key string) (*candidates, bool), stats *ngramStats, key string, word uint32) float64 {
+	var candidates, exists = lookup(key)
+
+	// unigrams are the last resort, so they're not discounted
+	if len(key) == 0 {
+		if !exists {
+			return 0
+		}
+		return candidates.probability(word, stats.minCount)
+	}
+
+	var lower = key[idSize:]
+	if !exists {
+		return b.probability(lookup, stats, lower, word)
+	}
+
+	var countOfCounts = stats.orderCountOfCounts[len(key)/idSize]
+	var total = float64(candidates.total(stats.minCount))
+	if frequency := stats.frequency(candidates, word); frequency > 0 {
+		return goodTuringDiscount(countOfCounts, frequency) * float64(frequency) / total
+	}
+
+	// the mass left by the discounts is shared among the words that never
+	// followed the key, weighted by their lower order probability
+	var kept, covered = 0.0, 0.0
+	for _, wf := range candidates.words {
+		if wf.frequency < stats.minCount {
+			continue
+		}
+
+		kept += goodTuringDiscount(countOfCounts, wf.frequency) * float64(wf.frequency) / total
+		covered += b.probability(lookup, stats, lower, wf.word)
+	}
+
+	if kept >= 1 || covered >= 1 {
+		return 0
+	}
+
+	return (1 - kept) / (1 - covered) * b.probability(lookup, stats, lower, word)
+}
@@ -0,0 +1,301 @@
+package markov
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestChain_Add_backoff(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewChain[string](3, WithBackoff(StupidBackoff(0.4)))
+	if err := chain.Add([]string{"a", "b", "a", "b", "c"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wantEntries = []testEntry{
+		{prefix: []string{"a", "b"}, candidates: []testCandidate{{"a", 1}, {"c", 1}}},
+		{prefix: []string{"b", "a"}, candidates: []testCandidate{{"b", 1}}},
+	}
+	if entries := sortedEntries(chain); !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+
+	var wantLower = []testEntry{
+		{prefix: []string{}, candidates: []testCandidate{{"a", 2}, {"b", 2}, {"c", 1}}},
+		{prefix: []string{"a"}, candidates: []testCandidate{{"b", 2}}},
+		{prefix: []string{"b"}, candidates: []testCandidate{{"a", 1}, {"c", 1}}},
+	}
+	if entries := lowerEntries(chain); !reflect.DeepEqual(entries, wantLower) {
+		t.Errorf("got %v, want %v", entries, wantLower)
+	}
+}
+
+func TestChain_Next_backoff(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		prefix []string
+
+		wantNext   string
+		wantExists bool
+	}{
+		{
+			name:       "ok - known prefix",
+			prefix:     []string{"a", "b"},
+			wantNext:   "c",
+			wantExists: true,
+		},
+		{
+			name:       "ok - backoff to bigram",
+			prefix:     []string{"c", "b"},
+			wantNext:   "c",
+			wantExists: true,
+		},
+		{
+			name:       "ok - backoff to unigram",
+			prefix:     []string{"x", "y"},
+			wantNext:   "a",
+			wantExists: true,
+		},
+		{
+			name:       "ok - shorter prefix",
+			prefix:     []string{"a"},
+			wantNext:   "b",
+			wantExists: true,
+		},
+	}
+
+	var chain, _ = NewChain[string](3, WithBackoff(StupidBackoff(0.4)))
+	chain.randFunc = dummyRandFunc
+	chain.Add([]string{"a", "b", "c"})
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var next, exists = chain.Next(tt.prefix)
+			if next != tt.wantNext || exists != tt.wantExists {
+				t.Errorf("got %v %v, want %v %v", next, exists, tt.wantNext, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestChain_Generate_backoff(t *testing.T) {
+	t.Parallel()
+
+	var plain, _ = NewChain[string](3, WithSeed(1))
+	var backoff, _ = NewChain[string](3, WithSeed(1), WithBackoff(StupidBackoff(0.4)))
+	for _, chain := range []*Chain[string]{plain, backoff} {
+		chain.Add([]string{"a", "b", "c"})
+	}
+
+	// the plain chain stops at the unknown prefix b c
+	if sequence := plain.Generate(10); len(sequence) != 3 {
+		t.Errorf("got %v, want %v symbols", sequence, 3)
+	}
+
+	if sequence := backoff.Generate(10); len(sequence) != 12 {
+		t.Errorf("got %v, want %v symbols", sequence, 12)
+	}
+}
+
+func TestStupidBackoff(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		prefix string
+		next   string
+
+		wantProbability float32
+	}{
+		{
+			name:            "ok - known ngram",
+			prefix:          "a b",
+			next:            "c",
+			wantProbability: 0.5,
+		},
+		{
+			name:            "ok - backoff to bigram",
+			prefix:          "a c",
+			next:            "a",
+			wantProbability: 0.4,
+		},
+		{
+			name:            "ok - backoff to unigram",
+			prefix:          "x y",
+			next:            "c",
+			wantProbability: 0.4 * 0.4 / 6,
+		},
+		{
+			name:            "ok - unknown symbol",
+			prefix:          "a b",
+			next:            "z",
+			wantProbability: 0,
+		},
+	}
+
+	var chain, _ = NewNGramChain(3, WithBackoff(StupidBackoff(0.4)))
+	chain.ProcessText(strings.NewReader("a b c a b a"))
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var probability, err = chain.CandidateProbability(tt.prefix, tt.next)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !almostEqual(float64(probability), float64(tt.wantProbability)) {
+				t.Errorf("got %v, want %v", probability, tt.wantProbability)
+			}
+		})
+	}
+}
+
+func TestKatzBackoff(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithBackoff(KatzBackoff()))
+	chain.ProcessText(strings.NewReader(smoothingText))
+
+	chain.chain.lock.RLock()
+	defer chain.chain.lock.RUnlock()
+
+	var unigrams, _ = chain.chain.candidates("")
+
+	// the distributions add up to 1 over the known symbols for any prefix
+	for _, prefix := range []string{"I am", "a wonderful", "You are", "am"} {
+		var key = chain.prefixKey(prefix)
+
+		var sum = 0.0
+		for _, wf := range unigrams.words {
+			var probability, err = chain.chain.probabilityID(key, wf.word)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sum += probability
+		}
+
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("prefix %q: got %v, want %v", prefix, sum, 1)
+		}
+	}
+
+	// unseen candidates of a known prefix get some of the discounted mass
+	if unseen, _ := chain.chain.probability(chain.prefixKey("I am"), "world."); unseen <= 0 {
+		t.Errorf("got %v, want a positive probability", unseen)
+	}
+}
+
+func TestNGramChain_ScoreText_backoff(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2, WithBackoff(StupidBackoff(0.5)))
+	chain.ProcessText(strings.NewReader("I am batman."))
+
+	var score, err = chain.ScoreText(strings.NewReader("You am batman."))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the first tokens are scored with the unigrams
+	var wantTokens = []TokenScore{
+		{Token: "You", Probability: 0},
+		{Token: "am", Probability: 0.5 * 1.0 / 3},
+		{Token: "batman.", Probability: 1},
+	}
+	if !reflect.DeepEqual(score.Tokens, wantTokens) {
+		t.Errorf("got %v, want %v", score.Tokens, wantTokens)
+	}
+}
+
+func TestNGramChain_SaveLoad_backoff(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithBackoff(KatzBackoff()))
+	chain.ProcessText(strings.NewReader(smoothingText))
+
+	var buf bytes.Buffer
+	if err := chain.Save(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var data = buf.Bytes()
+
+	var restored, _ = NewNGramChain(3, WithBackoff(KatzBackoff()))
+	if err := restored.Load(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(sortedEntries(restored.chain), sortedEntries(chain.chain)) {
+		t.Errorf("got %v, want %v", sortedEntries(restored.chain), sortedEntries(chain.chain))
+	}
+
+	if !reflect.DeepEqual(lowerEntries(restored.chain), lowerEntries(chain.chain)) {
+		t.Errorf("got %v, want %v", lowerEntries(restored.chain), lowerEntries(chain.chain))
+	}
+
+	// chains without backoff can't hold the lower orders
+	var plain, _ = NewNGramChain(3)
+	if err := plain.Load(bytes.NewReader(data)); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("got %v, want %v", err, ErrInvalidFormat)
+	}
+}
+
+func TestNGramChain_JSONRoundTrip_backoff(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithBackoff(StupidBackoff(0.4)))
+	chain.ProcessText(strings.NewReader(smoothingText))
+
+	var data, err = chain.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var restored, _ = NewNGramChain(3, WithBackoff(StupidBackoff(0.4)))
+	if err := restored.UnmarshalJSON(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(lowerEntries(restored.chain), lowerEntries(chain.chain)) {
+		t.Errorf("got %v, want %v", lowerEntries(restored.chain), lowerEntries(chain.chain))
+	}
+
+	var plain NGramChain
+	if err := plain.UnmarshalJSON(data); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("got %v, want %v", err, ErrInvalidFormat)
+	}
+}
+
+// lowerEntries returns the readable state of the chain lower orders, sorted by
+// prefix
+func lowerEntries(c *Chain[string]) []testEntry {
+	var entries []testEntry
+	c.lower.each(func(key string, candidates *candidates) bool {
+		var entry = testEntry{prefix: c.symbols.symbols(unpackKey(key))}
+		for _, wf := range candidates.words {
+			entry.candidates = append(entry.candidates, testCandidate{word: c.symbols.value(wf.word), frequency: wf.frequency})
+		}
+		entries = append(entries, entry)
+		return true
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return slices.Compare(entries[i].prefix, entries[j].prefix) < 0
+	})
+
+	return entries
+}
@@ -1,64 +1,226 @@
 package markov
 
-// candidates represents a list of words that have followed a given bigram with
-// their respective frequencies. It also keeps track of the total number of
-// bigram occurences.
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// candidates represents a list of symbols that have followed a given prefix
+// with their respective frequencies. It also keeps track of the total number of
+// prefix occurences.
 type candidates struct {
 	words       []wordFrequency
 	occurrences int
+
+	// index maps the words to their position in the list. It's only built
+	// once the list is long enough for a map lookup to beat a linear scan, so
+	// the many prefixes with a handful of candidates don't pay for it
+	index map[uint32]int
+
+	// sampler is built on the first selection and dropped on every write, so
+	// generation doesn't walk the whole list on every call. It's atomic since
+	// selections only hold the chain read lock
+	sampler atomic.Pointer[sampler]
 }
 
-// wordFrequency represents a word and its frequency.
+// indexThreshold is the number of words from which candidates keep an index
+// and a sampler
+const indexThreshold = 16
+
+// sampler is the cumulative frequency of the candidates seen at least minCount
+// times, which can be searched to select a candidate in O(log n)
+type sampler struct {
+	minCount   int
+	words      []uint32
+	cumulative []int
+}
+
+// wordFrequency represents the ID of a symbol and its frequency.
 type wordFrequency struct {
-	word      string
+	word      uint32
 	frequency int
 }
 
-func (c *candidates) processCandidate(candidate string) {
-	// increase occurences counter for the bigram
-	c.occurrences++
+func (c *candidates) processCandidate(candidate uint32) {
+	c.addCandidate(candidate, 1)
+}
+
+// addCandidate increases by frequency the occurrences of the candidate,
+// adding it if it doesn't exist
+func (c *candidates) addCandidate(candidate uint32, frequency int) {
+	// increase occurences counter for the prefix
+	c.occurrences += frequency
 
+	// the frequencies are changing, so the sampler needs to be rebuilt
+	if c.sampler.Load() != nil {
+		c.sampler.Store(nil)
+	}
+
+	// if the candidate already exists, increase frequency
+	if i := c.find(candidate); i >= 0 {
+		c.words[i].frequency += frequency
+		return
+	}
+
+	// if candidate doesn't exist, add it
+	c.words = append(c.words, wordFrequency{word: candidate, frequency: frequency})
+
+	switch {
+	case c.index != nil:
+		c.index[candidate] = len(c.words) - 1
+	case len(c.words) >= indexThreshold:
+		c.index = make(map[uint32]int, len(c.words))
+		for i, wf := range c.words {
+			c.index[wf.word] = i
+		}
+	}
+}
+
+// find returns the position of the word in the list, or -1 if it's not a
+// candidate
+func (c *candidates) find(word uint32) int {
+	if c.index == nil {
+		return c.scan(word)
+	}
+
+	if i, exists := c.index[word]; exists {
+		return i
+	}
+
+	return -1
+}
+
+// scan looks for the word going through the whole list
+func (c *candidates) scan(word uint32) int {
 	for i, wf := range c.words {
-		// if the candidate already exists, increase frequency and stop looking
-		if candidate == wf.word {
-			c.words[i].frequency++
-			return
+		if wf.word == word {
+			return i
 		}
 	}
 
-	// if candidate doesn't exist, add it
-	c.words = append(c.words, wordFrequency{word: candidate, frequency: 1})
+	return -1
 }
 
-func (c *candidates) selectCandidate(randFunc func(int) int) string {
-	// get a random number in the range of the bigram occurences
-	var randomPos = randFunc(c.occurrences)
+func (c *candidates) selectCandidate(randFunc func(int) int, minCount int) (uint32, bool) {
+	var total = c.total(minCount)
+	if total == 0 {
+		return 0, false
+	}
+
+	// get a random number in the range of the prefix occurences
+	var randomPos = randFunc(total)
+
+	if len(c.words) >= indexThreshold {
+		return c.getSampler(minCount).search(randomPos)
+	}
 
 	var counter = 0
 
 	// for each word increase the counter based on their frequency to weight the
-	// probability of the different candidates
+	// probability of the different candidates. Words seen less than minCount
+	// times are ignored
 	for _, wordFreq := range c.words {
+		if wordFreq.frequency < minCount {
+			continue
+		}
+
 		counter += wordFreq.frequency
 		if counter > randomPos {
-			return wordFreq.word
+			return wordFreq.word, true
 		}
 	}
 
 	// this should only happen if the occurences are somehow not aligned with
 	// the frequencies (sum(frequencies) != occurences)
-	//
-	// It could be handled as an error, but I kept it as an empty string for
-	// simplicity
-	return ""
+	return 0, false
 }
 
-func (c *candidates) getCandidate(word string) *wordFrequency {
-	for _, candidate := range c.words {
-		if candidate.word == word {
-			return &candidate
+// getSampler returns the sampler of the candidates seen at least minCount
+// times, building it if needed. Concurrent callers could build it more than
+// once, which is harmless since they all build the same one
+func (c *candidates) getSampler(minCount int) *sampler {
+	if s := c.sampler.Load(); s != nil && s.minCount == minCount {
+		return s
+	}
+
+	var s = &sampler{minCount: minCount}
+	var counter = 0
+	for _, wordFreq := range c.words {
+		if wordFreq.frequency < minCount {
+			continue
+		}
+
+		counter += wordFreq.frequency
+		s.words = append(s.words, wordFreq.word)
+		s.cumulative = append(s.cumulative, counter)
+	}
+
+	c.sampler.Store(s)
+
+	return s
+}
+
+// search returns the candidate at the given position of the cumulative
+// frequency, or false if it's out of range
+func (s *sampler) search(pos int) (uint32, bool) {
+	var i = sort.Search(len(s.cumulative), func(i int) bool {
+		return s.cumulative[i] > pos
+	})
+
+	if i == len(s.cumulative) {
+		return 0, false
+	}
+
+	return s.words[i], true
+}
+
+// total returns the sum of the frequencies in the sampler
+func (s *sampler) total() int {
+	if len(s.cumulative) == 0 {
+		return 0
+	}
+
+	return s.cumulative[len(s.cumulative)-1]
+}
+
+// total returns the number of occurrences of the candidates seen at least
+// minCount times
+func (c *candidates) total(minCount int) int {
+	if minCount <= 1 {
+		return c.occurrences
+	}
+
+	if len(c.words) >= indexThreshold {
+		return c.getSampler(minCount).total()
+	}
+
+	var total = 0
+	for _, wordFreq := range c.words {
+		if wordFreq.frequency >= minCount {
+			total += wordFreq.frequency
 		}
 	}
 
-	return nil
+	return total
+}
+
+// probability returns the probability of the word among the candidates seen
+// at least minCount times
+func (c *candidates) probability(word uint32, minCount int) float64 {
+	var wordFreq = c.getCandidate(word)
+	if wordFreq == nil || wordFreq.frequency < minCount {
+		return 0.0
+	}
+
+	return float64(wordFreq.frequency) / float64(c.total(minCount))
+}
+
+func (c *candidates) getCandidate(word uint32) *wordFrequency {
+	var i = c.find(word)
+	if i < 0 {
+		return nil
+	}
+
+	var candidate = c.words[i]
+	return &candidate
 }
@@ -1,6 +1,8 @@
 package markov
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -11,7 +13,7 @@ func TestCandidates_processCandidate(t *testing.T) {
 	var getValidCandidates = func() *candidates {
 		return &candidates{
 			words: []wordFrequency{
-				{word: "potato", frequency: 1},
+				{word: potato, frequency: 1},
 			},
 			occurrences: 1,
 		}
@@ -20,20 +22,20 @@ func TestCandidates_processCandidate(t *testing.T) {
 	var tests = []struct {
 		name       string
 		candidates *candidates
-		input      string
+		input      uint32
 
 		wantCandidates *candidates
 	}{
 		{
 			name:       "ok - add new candidate",
 			candidates: getValidCandidates(),
-			input:      "banana",
+			input:      banana,
 			wantCandidates: func() *candidates {
 				var c = getValidCandidates()
 				c.occurrences = 2
 				c.words = []wordFrequency{
-					{word: "potato", frequency: 1},
-					{word: "banana", frequency: 1},
+					{word: potato, frequency: 1},
+					{word: banana, frequency: 1},
 				}
 				return c
 			}(),
@@ -41,12 +43,12 @@ func TestCandidates_processCandidate(t *testing.T) {
 		{
 			name:       "ok - existing candidate",
 			candidates: getValidCandidates(),
-			input:      "potato",
+			input:      potato,
 			wantCandidates: func() *candidates {
 				var c = getValidCandidates()
 				c.occurrences = 2
 				c.words = []wordFrequency{
-					{word: "potato", frequency: 2},
+					{word: potato, frequency: 2},
 				}
 				return c
 			}(),
@@ -67,6 +69,39 @@ func TestCandidates_processCandidate(t *testing.T) {
 	}
 }
 
+func TestCandidates_index(t *testing.T) {
+	t.Parallel()
+
+	var c = &candidates{}
+	for i := 0; i < 3*indexThreshold; i++ {
+		// add every word twice, so existing words are looked up too
+		c.processCandidate(uint32(i))
+		c.processCandidate(uint32(i))
+	}
+
+	if len(c.index) != len(c.words) {
+		t.Fatalf("got %v indexed words, want %v", len(c.index), len(c.words))
+	}
+
+	for i, wf := range c.words {
+		if c.index[wf.word] != i {
+			t.Errorf("got %v, want %v", c.index[wf.word], i)
+		}
+
+		if wf.frequency != 2 {
+			t.Errorf("got %v, want %v", wf.frequency, 2)
+		}
+	}
+
+	if wf := c.getCandidate(indexThreshold); wf == nil || wf.word != indexThreshold {
+		t.Errorf("got %v, want %v", wf, indexThreshold)
+	}
+
+	if wf := c.getCandidate(1000); wf != nil {
+		t.Errorf("got %v, want %v", wf, nil)
+	}
+}
+
 func TestCandidates_selectCandidate(t *testing.T) {
 	t.Parallel()
 
@@ -74,20 +109,48 @@ func TestCandidates_selectCandidate(t *testing.T) {
 		name       string
 		candidates *candidates
 		randFunc   func(int) int
+		minCount   int
 
-		wantWord string
+		wantWord uint32
+		wantOK   bool
 	}{
 		{
 			name:       "ok -  potato",
 			candidates: getValidCandidates(),
 			randFunc:   func(int) int { return 0 },
-			wantWord:   "potato",
+			wantWord:   potato,
+			wantOK:     true,
 		},
 		{
 			name:       "ok - tomato",
 			candidates: getValidCandidates(),
 			randFunc:   func(int) int { return 6 },
-			wantWord:   "tomato",
+			wantWord:   tomato,
+			wantOK:     true,
+		},
+		{
+			name:       "ok - tomato with min count",
+			candidates: getValidCandidates(),
+			randFunc:   func(int) int { return 4 },
+			minCount:   5,
+			wantWord:   tomato,
+			wantOK:     true,
+		},
+		{
+			name:       "ok - banana with min count",
+			candidates: getValidCandidates(),
+			randFunc:   func(int) int { return 3 },
+			minCount:   2,
+			wantWord:   banana,
+			wantOK:     true,
+		},
+		{
+			name:       "ok - no candidates over min count",
+			candidates: getValidCandidates(),
+			randFunc:   func(int) int { return 0 },
+			minCount:   6,
+			wantWord:   0,
+			wantOK:     false,
 		},
 		{
 			name: "invalid candidates occurrences",
@@ -97,7 +160,8 @@ func TestCandidates_selectCandidate(t *testing.T) {
 				return c
 			}(),
 			randFunc: func(int) int { return 15 },
-			wantWord: "",
+			wantWord: 0,
+			wantOK:   false,
 		},
 	}
 
@@ -107,35 +171,78 @@ func TestCandidates_selectCandidate(t *testing.T) {
 			t.Parallel()
 
 			var candidates = getValidCandidates()
-			var word = candidates.selectCandidate(tt.randFunc)
+			var word, ok = candidates.selectCandidate(tt.randFunc, tt.minCount)
 
 			if !reflect.DeepEqual(word, tt.wantWord) {
 				t.Errorf("got %v, want %v", word, tt.wantWord)
 			}
+
+			if ok != tt.wantOK {
+				t.Errorf("got %v, want %v", ok, tt.wantOK)
+			}
 		})
 	}
 }
 
+func TestCandidates_selectCandidate_sampler(t *testing.T) {
+	t.Parallel()
+
+	// word i has frequency i+1, so the word at every position is known
+	var c = &candidates{}
+	for i := 0; i < 2*indexThreshold; i++ {
+		c.addCandidate(uint32(i), i+1)
+	}
+
+	var wantWord = func(pos, minCount int) uint32 {
+		var counter = 0
+		for _, wf := range c.words {
+			if wf.frequency < minCount {
+				continue
+			}
+			if counter += wf.frequency; counter > pos {
+				return wf.word
+			}
+		}
+		return 0
+	}
+
+	for _, minCount := range []int{1, indexThreshold} {
+		for pos := 0; pos < c.total(minCount); pos++ {
+			var word, ok = c.selectCandidate(func(int) int { return pos }, minCount)
+			if !ok || word != wantWord(pos, minCount) {
+				t.Fatalf("min count %v, position %v: got %v, want %v", minCount, pos, word, wantWord(pos, minCount))
+			}
+		}
+	}
+
+	// writes must invalidate the sampler
+	c.processCandidate(1000)
+	var word, ok = c.selectCandidate(func(n int) int { return n - 1 }, 1)
+	if !ok || word != 1000 {
+		t.Errorf("got %v, want %v", word, 1000)
+	}
+}
+
 func TestCandidates_getCandidate(t *testing.T) {
 	t.Parallel()
 
 	var tests = []struct {
 		name  string
-		input string
+		input uint32
 
 		wantWordFreq *wordFrequency
 	}{
 		{
 			name:  "ok",
-			input: "banana",
+			input: banana,
 			wantWordFreq: &wordFrequency{
-				word:      "banana",
+				word:      banana,
 				frequency: 4,
 			},
 		},
 		{
 			name:         "candidate not found",
-			input:        "platano",
+			input:        platano,
 			wantWordFreq: nil,
 		},
 	}
@@ -155,12 +262,114 @@ func TestCandidates_getCandidate(t *testing.T) {
 	}
 }
 
+func TestCandidates_total(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name     string
+		minCount int
+
+		wantTotal int
+	}{
+		{
+			name:      "ok - no min count",
+			minCount:  0,
+			wantTotal: 10,
+		},
+		{
+			name:      "ok - min count of 1",
+			minCount:  1,
+			wantTotal: 10,
+		},
+		{
+			name:      "ok - min count filtering",
+			minCount:  4,
+			wantTotal: 9,
+		},
+		{
+			name:      "ok - everything filtered",
+			minCount:  6,
+			wantTotal: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var c = getValidCandidates()
+			if total := c.total(tt.minCount); total != tt.wantTotal {
+				t.Errorf("got %v, want %v", total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func BenchmarkCandidates_processCandidate(b *testing.B) {
+	for _, fanOut := range []int{4, 64, 1024, 16384} {
+		b.Run(fmt.Sprintf("followers=%d", fanOut), func(b *testing.B) {
+			var c = &candidates{}
+			for i := 0; i < b.N; i++ {
+				c.processCandidate(uint32(i % fanOut))
+			}
+		})
+	}
+}
+
+func BenchmarkCandidates_getCandidate(b *testing.B) {
+	for _, fanOut := range []int{4, 64, 1024, 16384} {
+		var c = &candidates{}
+		for i := 0; i < fanOut; i++ {
+			c.processCandidate(uint32(i))
+		}
+
+		b.Run(fmt.Sprintf("followers=%d/indexed", fanOut), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.find(uint32(i % fanOut))
+			}
+		})
+
+		// the linear scan used before the index, as a baseline
+		b.Run(fmt.Sprintf("followers=%d/scan", fanOut), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.scan(uint32(i % fanOut))
+			}
+		})
+	}
+}
+
+func BenchmarkCandidates_selectCandidate(b *testing.B) {
+	var randFunc = rand.New(rand.NewSource(1)).Intn
+
+	for _, fanOut := range []int{4, 64, 1024, 16384} {
+		var c = &candidates{}
+		for i := 0; i < fanOut; i++ {
+			c.addCandidate(uint32(i), i%10+1)
+		}
+
+		b.Run(fmt.Sprintf("followers=%d", fanOut), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.selectCandidate(randFunc, 1)
+			}
+		})
+	}
+}
+
+// IDs of the symbols used by the candidates tests
+const (
+	potato uint32 = firstSymbolID + iota
+	banana
+	tomato
+	platano
+)
+
 func getValidCandidates() *candidates {
 	return &candidates{
 		words: []wordFrequency{
-			{word: "potato", frequency: 1},
-			{word: "banana", frequency: 4},
-			{word: "tomato", frequency: 5},
+			{word: potato, frequency: 1},
+			{word: banana, frequency: 4},
+			{word: tomato, frequency: 5},
 		},
 		occurrences: 10,
 	}
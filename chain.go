@@ -0,0 +1,591 @@
+package markov
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// Chain processes ngrams of any comparable symbol, like words, music notes or
+// clickstream events. It keeps them in a Store, in memory by default, using n-1
+// symbol prefixes as keys and a list of candidates as values. Symbols are
+// interned as IDs, so keys are compact and never ambiguous. It's safe for
+// concurrent use
+type Chain[T comparable] struct {
+	store ngramStore
+	n     uint
+
+	// lower holds the ngrams of every order below n, keyed by their shorter
+	// prefixes, down to the unigrams under the empty key. It's only used by
+	// chains with backoff
+	lower   ngramStore
+	backoff Backoff
+
+	symbols *symbolTable[T]
+
+	seeds    []string
+	randFunc func(n int) int
+	lock     *chainLock
+
+	// seedLock guards the seeds while the writers of a striped store share
+	// the write lock
+	seedLock sync.Mutex
+
+	// bounded chains process their input as sequences padded with start and
+	// end boundaries
+	bounded bool
+
+	minCount   int
+	seedPolicy func(prefix []T) bool
+
+	// smoothing is optional. Its stats are computed on the first smoothed
+	// probability and dropped on every write
+	smoothing Smoothing
+	stats     atomic.Pointer[ngramStats]
+
+	// suffixes indexes the keys to continue the prompts of chains without
+	// backoff from. It's built on the first prompt the chain doesn't know and
+	// dropped whenever a key is added
+	suffixes atomic.Pointer[suffixIndex]
+}
+
+// Add will process the sequence, adding every ngram in it to the chain. Ngrams
+// don't span across different sequences. If the chain was created with
+// WithSequenceBoundaries, the sequence is padded with start and end
+// boundaries, otherwise sequences shorter than n are ignored.
+func (c *Chain[T]) Add(sequence []T) error {
+	var window = c.newWindow()
+	for _, symbol := range sequence {
+		if err := window.push(symbol); err != nil {
+			return err
+		}
+	}
+
+	return window.close()
+}
+
+// Next will select and return a symbol to follow the given n-1 symbols prefix,
+// keeping random selection weighted by frequency. It returns false if the
+// prefix doesn't exist or, for chains with sequence boundaries, if the end of
+// the sequence was selected. Chains with backoff use the longest suffix of the
+// prefix they know instead.
+func (c *Chain[T]) Next(prefix []T) (T, bool) {
+	var zero T
+	var key = c.key(prefix)
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var id, exists = c.next(key)
+	if !exists || (c.bounded && id == endID) {
+		return zero, false
+	}
+
+	return c.symbols.value(id), true
+}
+
+// Generate will generate a random sequence using the learnt ngrams keeping
+// random selection of candidates weighted by frequency. Chains with sequence
+// boundaries generate a sequence from its beginning, stopping at its end or
+// after maxLen symbols. Otherwise, generation starts with a random seed, or any
+// prefix if there are no seeds, followed by up to maxLen symbols. Chains with
+// backoff keep generating after reaching an unknown prefix.
+func (c *Chain[T]) Generate(maxLen uint) []T {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var ids = c.generateSequence(maxLen)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return c.symbols.symbols(ids)
+}
+
+// Probability will check what the probability of a given symbol is for a given
+// n-1 symbols prefix. If the symbol never followed the prefix, 0 is returned.
+// If the prefix does not exist, an error is returned, unless the chain is
+// smoothed or has backoff.
+func (c *Chain[T]) Probability(prefix []T, next T) (float64, error) {
+	var key = c.key(prefix)
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.probability(key, next)
+}
+
+// generateSequence will generate the IDs of a random sequence as described by
+// Generate. The caller must hold the read lock.
+func (c *Chain[T]) generateSequence(maxLen uint) []uint32 {
+	var key, exists = c.start()
+	if !exists {
+		return nil
+	}
+
+	var ids, _ = c.generate(key, maxLen)
+	if c.bounded {
+		return ids
+	}
+
+	return append(unpackKey(key), ids...)
+}
+
+// start returns the key a random sequence starts from, or false if the store
+// is empty. Bounded chains always start at the beginning of a sequence, the
+// others with a random seed, which is part of the sequence. The caller must
+// hold the read lock.
+func (c *Chain[T]) start() (string, bool) {
+	// if the store is empty, no sequence to generate
+	if c.store.len() == 0 {
+		return "", false
+	}
+
+	if c.bounded {
+		return c.startKey(), true
+	}
+
+	return c.getRandomNGram(), true
+}
+
+// stream returns an iterator over the IDs of a random sequence, like
+// generateSequence, with up to maxLen selected candidates or no limit if
+// maxLen is 0. The read lock is only held while selecting every candidate, so
+// the chain can be written while the sequence is consumed. It stops early if
+// the context is done.
+func (c *Chain[T]) stream(ctx context.Context, maxLen uint) iter.Seq[uint32] {
+	return func(yield func(id uint32) bool) {
+		c.lock.RLock()
+		var key, exists = c.start()
+		c.lock.RUnlock()
+
+		if !exists {
+			return
+		}
+
+		var window = unpackKey(key)
+		if !c.bounded {
+			for _, id := range window {
+				if ctx.Err() != nil || !yield(id) {
+					return
+				}
+			}
+		}
+
+		for i := uint(0); maxLen == 0 || i < maxLen; i++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			c.lock.RLock()
+			var id, exists = c.next(key)
+			c.lock.RUnlock()
+
+			if !exists || (c.bounded && id == endID) || !yield(id) {
+				return
+			}
+
+			// generate the new key with the selected candidate
+			window = c.slide(window, id)
+			key = packKey(window)
+		}
+	}
+}
+
+// generate will select up to maxLen candidates starting from the given key,
+// feeding every selected candidate back to build the next key. It returns the
+// selected candidates and whether the generation stopped because the end of a
+// sequence was reached. The caller must hold the read lock.
+func (c *Chain[T]) generate(key string, maxLen uint) ([]uint32, bool) {
+	var window = unpackKey(key)
+	// maxLen is only an upper bound, so the IDs aren't preallocated for it
+	var ids []uint32
+
+	for i := uint(0); i < maxLen; i++ {
+		var id, exists = c.next(key)
+		if !exists {
+			// if the key doesn't exist, end the generation
+			break
+		}
+
+		if c.bounded && id == endID {
+			return ids, true
+		}
+
+		ids = append(ids, id)
+
+		// generate the new key with the selected candidate
+		window = c.slide(window, id)
+		key = packKey(window)
+	}
+
+	return ids, false
+}
+
+// resume returns the key to continue a sequence ending with the given key
+// from, or false if there's none. Chains with backoff continue from the key
+// itself, backing off to its known suffixes. The others continue from the key
+// if they know it, or else from a key ending with its longest known suffix,
+// picked in proportion to its occurrences. The caller must hold the read lock.
+func (c *Chain[T]) resume(key string) (string, bool) {
+	if _, exists := c.candidates(key); exists || c.backoff != nil {
+		return key, true
+	}
+
+	if len(key) == 0 {
+		return "", false
+	}
+
+	var longest, weight = 0, 0
+	var resumed string
+	for _, stored := range c.keysEnding(keyID(key, len(key)-idSize)) {
+		var candidates, _ = c.store.get(stored)
+		var total = candidates.total(c.minCount)
+		var suffix = commonSuffix(stored, key)
+		if total == 0 || suffix < longest {
+			continue
+		}
+
+		if suffix > longest {
+			longest, weight = suffix, 0
+		}
+
+		// every key with the longest suffix replaces the previous one with
+		// the probability of its share of the occurrences
+		weight += total
+		if c.randFunc(weight) < total {
+			resumed = stored
+		}
+	}
+
+	return resumed, longest > 0
+}
+
+// suffixIndex holds the keys of the store by their last ID
+type suffixIndex struct {
+	keys map[uint32][]string
+}
+
+// keysEnding returns the keys of the store ending with the given ID, indexing
+// them if the store got new keys since the last call. The caller must hold the
+// read lock.
+func (c *Chain[T]) keysEnding(id uint32) []string {
+	if index := c.suffixes.Load(); index != nil {
+		return index.keys[id]
+	}
+
+	var index = &suffixIndex{keys: make(map[uint32][]string)}
+	c.store.each(func(key string, _ *candidates) bool {
+		if len(key) > 0 {
+			var last = keyID(key, len(key)-idSize)
+			index.keys[last] = append(index.keys[last], key)
+		}
+		return true
+	})
+	c.suffixes.Store(index)
+
+	return index.keys[id]
+}
+
+// commonSuffix returns the number of IDs both keys end with
+func commonSuffix(a, b string) int {
+	var count = 0
+	for i, j := len(a), len(b); i > 0 && j > 0 && a[i-idSize:i] == b[j-idSize:j]; i, j = i-idSize, j-idSize {
+		count++
+	}
+
+	return count
+}
+
+// slide adds the selected ID to the window of the last IDs generated, dropping
+// the first one once the window holds n-1 IDs. Shorter windows, which only
+// chains with backoff can continue, grow up to that.
+func (c *Chain[T]) slide(window []uint32, id uint32) []uint32 {
+	if len(window) < int(c.n)-1 {
+		return append(window, id)
+	}
+
+	copy(window, window[1:])
+	window[len(window)-1] = id
+
+	return window
+}
+
+// next selects a candidate for the given key, returning false if the key
+// doesn't exist. Chains with backoff select it from the longest suffix of the
+// key that exists instead. The caller must hold the read lock.
+func (c *Chain[T]) next(key string) (uint32, bool) {
+	var candidates, exists = c.candidates(key)
+	for !exists && c.backoff != nil && len(key) > 0 {
+		key = key[idSize:]
+		candidates, exists = c.candidates(key)
+	}
+
+	if !exists {
+		return 0, false
+	}
+
+	return candidates.selectCandidate(c.randFunc, c.minCount)
+}
+
+// probability returns the probability of the symbol following the given key.
+// The caller must hold the read lock.
+func (c *Chain[T]) probability(key string, symbol T) (float64, error) {
+	var id, known = c.symbols.id(symbol)
+	if !known {
+		id = noID
+	}
+
+	return c.probabilityID(key, id)
+}
+
+// probabilityID returns the probability of the symbol ID following the given
+// key. IDs unknown to the chain have a probability of 0, unless the chain is
+// smoothed. The caller must hold the read lock.
+func (c *Chain[T]) probabilityID(key string, id uint32) (float64, error) {
+	if c.backoff != nil {
+		return c.backoff.probability(c.candidates, c.getStats(), key, id), nil
+	}
+
+	var candidates, exists = c.candidates(key)
+	if c.smoothing != nil {
+		return c.smoothing.probability(c.getStats(), candidates, id), nil
+	}
+
+	if !exists {
+		return 0.0, errors.New("prefix does not exist")
+	}
+
+	return candidates.probability(id, c.minCount), nil
+}
+
+// candidates returns the candidates for the given key, which can be shorter
+// than n-1 symbols for chains with backoff. Keys without any candidate seen at
+// least minCount times are considered missing. The caller must hold the read
+// lock.
+func (c *Chain[T]) candidates(key string) (*candidates, bool) {
+	var candidates, exists = c.storeFor(key).get(key)
+	if !exists || candidates.total(c.minCount) == 0 {
+		return nil, false
+	}
+
+	return candidates, true
+}
+
+// stored returns the candidates of a key the chain holds, whatever their
+// count, or the error which stopped its store from reading them. The caller
+// must hold the read lock.
+func (c *Chain[T]) stored(key string) (*candidates, error) {
+	var store = c.storeFor(key)
+	if candidates, exists := store.get(key); exists {
+		return candidates, nil
+	}
+
+	if err := storeErr(store); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("prefix %v missing from the store", unpackKey(key))
+}
+
+// processNgram will lock the chain and add the ngram IDs to it, see addNgram
+func (c *Chain[T]) processNgram(ngram []uint32) error {
+	// lock the map to prevent racy reads while the writes are ongoing
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.addNgram(ngram, 1)
+}
+
+// addNgram will extract the key and candidate from the ngram IDs and either
+// add it to the map if it doesn't exist or increase by frequency/add the new
+// candidate. Chains with backoff also process every suffix of the ngram as a
+// lower order one, and accept ngrams shorter than n from the beginning of a
+// sequence. The caller must hold the write lock, or share it if the store is
+// striped.
+func (c *Chain[T]) addNgram(ngram []uint32, frequency int) error {
+	// in order to process the ngram we need n on input
+	var partial = c.backoff != nil && len(ngram) > 0 && len(ngram) < int(c.n)
+	if len(ngram) != int(c.n) && !partial {
+		return fmt.Errorf("error processing ngram, expected input length %d, got %d", c.n, len(ngram))
+	}
+
+	var prefix = ngram[:len(ngram)-1]
+	var candidate = ngram[len(ngram)-1]
+	var key = packKey(prefix)
+
+	if c.backoff != nil {
+		for i := 1; i <= len(prefix); i++ {
+			if _, err := c.add(packKey(prefix[i:]), candidate, frequency); err != nil {
+				return fmt.Errorf("error processing ngram: %w", err)
+			}
+		}
+	}
+
+	var created, err = c.add(key, candidate, frequency)
+	if err != nil {
+		return fmt.Errorf("error processing ngram: %w", err)
+	}
+
+	if !created || partial {
+		return nil
+	}
+
+	// if the seed policy accepts the new prefix, add it to the seed list.
+	// Bounded chains don't need seeds since they always start at the beginning
+	// of a sequence
+	if !c.bounded && c.seedPolicy != nil && c.seedPolicy(c.symbols.symbols(prefix)) {
+		c.seedLock.Lock()
+		c.seeds = append(c.seeds, key)
+		c.seedLock.Unlock()
+	}
+
+	return nil
+}
+
+// add increases by frequency the occurrences of the candidate after the given
+// key, adding the key to the map if it doesn't exist. Keys shorter than n-1
+// symbols go to the lower orders. It returns true if the key is new, and an
+// error if the store couldn't be written. The caller must hold the write lock,
+// or share it if the store is striped.
+func (c *Chain[T]) add(key string, candidate uint32, frequency int) (bool, error) {
+	// the counts are changing, so the smoothing stats need to be recomputed
+	if c.stats.Load() != nil {
+		c.stats.Store(nil)
+	}
+
+	var added, err = c.storeFor(key).increment(key, candidate, frequency)
+	if added && c.suffixes.Load() != nil {
+		c.suffixes.Store(nil)
+	}
+
+	return added, err
+}
+
+// validPrefix returns true if the chain can hold the ngrams of a prefix of
+// the given length: n-1 symbols, or less for chains with backoff
+func (c *Chain[T]) validPrefix(prefix []T) bool {
+	return len(prefix) == int(c.n)-1 || (c.backoff != nil && len(prefix) < int(c.n)-1)
+}
+
+// storeFor returns the store holding the given key: the lower orders for keys
+// shorter than n-1 symbols, the main one otherwise
+func (c *Chain[T]) storeFor(key string) ngramStore {
+	if c.isLower(key) {
+		return c.lower
+	}
+
+	return c.store
+}
+
+// striped returns true if the store of the chain locks its own stripes, so
+// several goroutines can add ngrams sharing the write lock
+func (c *Chain[T]) striped() bool {
+	return c.lock.striped
+}
+
+// isLower returns true if the key belongs to an ngram of lower order than n
+func (c *Chain[T]) isLower(key string) bool {
+	return len(key) < idSize*int(c.n-1)
+}
+
+// getRandomNGram returns a random key from the store. It will use the seeds if
+// available
+func (c *Chain[T]) getRandomNGram() string {
+	// if there are seeds use them
+	if len(c.seeds) > 0 {
+		return c.seeds[c.randFunc(len(c.seeds))]
+	}
+
+	// otherwise pick a random key from the store
+	var key, _ = c.store.entry(c.randFunc(c.store.len()))
+	return key
+}
+
+// key returns the store key for the given prefix. Unknown symbols are packed
+// as noID, so the key never exists but chains with backoff can still use its
+// known suffixes
+func (c *Chain[T]) key(prefix []T) string {
+	return packKey(c.symbols.lookup(prefix))
+}
+
+// internKey returns the store key for the given prefix, interning any unknown
+// symbol
+func (c *Chain[T]) internKey(prefix []T) string {
+	var ids = make([]uint32, len(prefix))
+	for i, symbol := range prefix {
+		ids[i] = c.symbols.intern(symbol)
+	}
+
+	return packKey(ids)
+}
+
+// replace swaps the content of the chain with the one of other, keeping the
+// chain settings
+func (c *Chain[T]) replace(other *Chain[T]) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.store = other.store
+	c.lower = other.lower
+	c.seeds = other.seeds
+	c.symbols.replace(other.symbols)
+	c.stats.Store(nil)
+	c.suffixes.Store(nil)
+}
+
+// NewChain will initialise a chain of symbols of type T. The n on input will
+// determine the length of the ngrams processed by the chain to produce the
+// key (n-1 symbols) and candidates. The options on input can be used to
+// customise the chain behaviour, see the With* functions. The options about
+// text, like the tokenizers, only apply to NGramChain and are ignored.
+func NewChain[T comparable](n uint, opts ...Option) (*Chain[T], error) {
+	if n <= 1 {
+		return nil, errors.New("error initialising Chain: n must be at least 2")
+	}
+
+	var cfg, err = newConfig(n, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising Chain: %w", err)
+	}
+
+	var chain = newChain[T](n, cfg)
+	if err := chain.openStore(cfg.symbolStore); err != nil {
+		return nil, fmt.Errorf("error initialising Chain: %w", err)
+	}
+
+	return chain, nil
+}
+
+// newChain returns a chain with the given settings. Its store is empty unless
+// one was set with WithStore, see openStore
+func newChain[T comparable](n uint, cfg *config) *Chain[T] {
+	var store = cfg.store
+	if store == nil {
+		store = newMapStore()
+	}
+
+	// the lower orders are kept in memory, in the same kind of store if
+	// possible
+	var lower = store.empty()
+	if lower == nil {
+		lower = newMapStore()
+	}
+
+	return &Chain[T]{
+		store:   store,
+		n:       n,
+		lower:   lower,
+		backoff: cfg.backoff,
+		symbols: newSymbolTable[T](),
+		// having the randFunc as a field of the chain allows for testing with deterministic output
+		randFunc:  cfg.randFunc,
+		lock:      newChainLock(isStriped(store)),
+		bounded:   cfg.bounded,
+		minCount:  cfg.minCount,
+		smoothing: cfg.smoothing,
+	}
+}
@@ -0,0 +1,307 @@
+package markov
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestChain_Add(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		opts      []Option
+		sequences [][]string
+
+		wantEntries []testEntry
+	}{
+		{
+			name:      "ok - ngrams don't span sequences",
+			sequences: [][]string{{"a", "b", "c"}, {"b", "c", "a"}},
+			wantEntries: []testEntry{
+				{prefix: []string{"a", "b"}, candidates: []testCandidate{{"c", 1}}},
+				{prefix: []string{"b", "c"}, candidates: []testCandidate{{"a", 1}}},
+			},
+		},
+		{
+			name:      "ok - symbols with spaces",
+			sequences: [][]string{{"a b", "c", "x"}, {"a", "b c", "y"}},
+			wantEntries: []testEntry{
+				{prefix: []string{"a b", "c"}, candidates: []testCandidate{{"x", 1}}},
+				{prefix: []string{"a", "b c"}, candidates: []testCandidate{{"y", 1}}},
+			},
+		},
+		{
+			name:      "ok - sequence boundaries",
+			opts:      []Option{WithSequenceBoundaries()},
+			sequences: [][]string{{"a"}, {}},
+			wantEntries: []testEntry{
+				{prefix: []string{StartToken, StartToken}, candidates: []testCandidate{{"a", 1}}},
+				{prefix: []string{StartToken, "a"}, candidates: []testCandidate{{EndToken, 1}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, err = NewChain[string](3, tt.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// the boundaries have no symbol on generic chains, name them so the
+			// entries are readable
+			chain.symbols.alias(StartToken, startID)
+			chain.symbols.alias(EndToken, endID)
+
+			for _, sequence := range tt.sequences {
+				if err := chain.Add(sequence); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if entries := storeEntries(chain); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("got %v, want %v", entries, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestChain_Next(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewChain[int](2, WithSequenceBoundaries())
+	chain.Add([]int{1, 2, 3})
+	chain.randFunc = dummyRandFunc
+
+	var tests = []struct {
+		name   string
+		prefix []int
+
+		wantSymbol int
+		wantOK     bool
+	}{
+		{
+			name:       "ok",
+			prefix:     []int{1},
+			wantSymbol: 2,
+			wantOK:     true,
+		},
+		{
+			name:       "ok - end of the sequence",
+			prefix:     []int{3},
+			wantSymbol: 0,
+			wantOK:     false,
+		},
+		{
+			name:       "ok - unknown prefix",
+			prefix:     []int{4},
+			wantSymbol: 0,
+			wantOK:     false,
+		},
+		{
+			name:       "ok - invalid prefix length",
+			prefix:     []int{1, 2},
+			wantSymbol: 0,
+			wantOK:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var symbol, ok = chain.Next(tt.prefix)
+			if symbol != tt.wantSymbol || ok != tt.wantOK {
+				t.Errorf("got %v %v, want %v %v", symbol, ok, tt.wantSymbol, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestChain_Generate(t *testing.T) {
+	t.Parallel()
+
+	type note string
+
+	var tests = []struct {
+		name      string
+		opts      []Option
+		sequences [][]note
+		maxLen    uint
+
+		wantSequence []note
+	}{
+		{
+			name:         "ok - sequence boundaries",
+			opts:         []Option{WithSequenceBoundaries()},
+			sequences:    [][]note{{"C", "D", "E"}, {"G", "C"}},
+			maxLen:       10,
+			wantSequence: []note{"C", "D", "E"},
+		},
+		{
+			name:         "ok - sequence boundaries max length",
+			opts:         []Option{WithSequenceBoundaries()},
+			sequences:    [][]note{{"C", "D", "E"}},
+			maxLen:       2,
+			wantSequence: []note{"C", "D"},
+		},
+		{
+			name:         "ok - random prefix",
+			sequences:    [][]note{{"E", "D", "C", "D", "E"}},
+			maxLen:       2,
+			wantSequence: []note{"E", "D", "C", "D"},
+		},
+		{
+			name:         "ok - empty chain",
+			maxLen:       10,
+			wantSequence: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewChain[note](3, tt.opts...)
+			for _, sequence := range tt.sequences {
+				chain.Add(sequence)
+			}
+			chain.randFunc = dummyRandFunc
+
+			var sequence = chain.Generate(tt.maxLen)
+			if !reflect.DeepEqual(sequence, tt.wantSequence) {
+				t.Errorf("got %v, want %v", sequence, tt.wantSequence)
+			}
+		})
+	}
+}
+
+func TestChain_Probability(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewChain[rune](2)
+	chain.Add([]rune("abacad"))
+
+	var tests = []struct {
+		name   string
+		prefix []rune
+		next   rune
+
+		wantProbability float64
+		wantErr         error
+	}{
+		{
+			name:            "ok",
+			prefix:          []rune("a"),
+			next:            'b',
+			wantProbability: 1.0 / 3,
+			wantErr:         nil,
+		},
+		{
+			name:            "ok - symbol never followed the prefix",
+			prefix:          []rune("b"),
+			next:            'c',
+			wantProbability: 0.0,
+			wantErr:         nil,
+		},
+		{
+			name:            "ok - unknown symbol",
+			prefix:          []rune("a"),
+			next:            'z',
+			wantProbability: 0.0,
+			wantErr:         nil,
+		},
+		{
+			name:            "ok - prefix doesn't exist",
+			prefix:          []rune("d"),
+			next:            'a',
+			wantProbability: 0.0,
+			wantErr:         errors.New("prefix does not exist"),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var probability, err = chain.Probability(tt.prefix, tt.next)
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+
+			if probability != tt.wantProbability {
+				t.Errorf("got %v, want %v", probability, tt.wantProbability)
+			}
+		})
+	}
+}
+
+func Test_NewChain(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		n    uint
+		opts []Option
+
+		wantErr error
+	}{
+		{
+			name:    "ok",
+			n:       2,
+			wantErr: nil,
+		},
+		{
+			name:    "error - invalid n",
+			n:       1,
+			wantErr: errors.New("error initialising Chain: n must be at least 2"),
+		},
+		{
+			name:    "error - invalid option",
+			n:       2,
+			opts:    []Option{WithMinCount(0)},
+			wantErr: fmt.Errorf("error initialising Chain: %w", errors.New("min count must be at least 1")),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var _, err = NewChain[string](tt.n, tt.opts...)
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_packKey(t *testing.T) {
+	t.Parallel()
+
+	var ids = []uint32{startID, endID, firstSymbolID, 1 << 31}
+	if got := unpackKey(packKey(ids)); !reflect.DeepEqual(got, ids) {
+		t.Errorf("got %v, want %v", got, ids)
+	}
+}
+
+func ExampleChain() {
+	// Create a chain of notes where every melody is a sequence
+	chain, _ := NewChain[string](2, WithSequenceBoundaries())
+
+	chain.Add([]string{"C", "E", "G"})
+
+	fmt.Println(chain.Generate(10))
+	// Output:
+	// [C E G]
+}
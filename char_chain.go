@@ -0,0 +1,103 @@
+package markov
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxWordAttempts is the number of words GenerateWord will try to generate
+// before giving up on finding one within the requested length
+const maxWordAttempts = 100
+
+// NewCharChain will initialise a character level ngram chain, useful to
+// generate words or names. Every word on input is processed as a sequence of
+// runes padded with StartToken and EndToken, so the n on input determines the
+// number of runes used as key. Additional options can be provided to customise
+// the chain, but the tokenizer should emit an EndToken at the end of every word.
+func NewCharChain(n uint, opts ...Option) (*NGramChain, error) {
+	var charOpts = []Option{
+		WithTokenizer(charTokenizer{}),
+		WithSequenceBoundaries(),
+	}
+
+	return NewNGramChain(n, append(charOpts, opts...)...)
+}
+
+// GenerateWord will generate a complete sequence, from its beginning to its
+// end, of between minLen and maxLen tokens. On a chain created with
+// NewCharChain, that's a word of between minLen and maxLen runes. It will
+// return an empty string if the chain is not bounded or no sequence within the
+// limits could be generated after several attempts.
+func (c *NGramChain) GenerateWord(minLen, maxLen uint) string {
+	if !c.chain.bounded || minLen > maxLen {
+		return ""
+	}
+
+	c.chain.lock.RLock()
+	defer c.chain.lock.RUnlock()
+
+	if c.chain.store.len() == 0 {
+		return ""
+	}
+
+	// generate one more token than allowed to know if the sequence would have
+	// ended within the limit, unless there's no limit
+	var limit = maxLen
+	if maxLen < math.MaxUint {
+		limit++
+	}
+
+	var start = c.chain.startKey()
+	for i := 0; i < maxWordAttempts; i++ {
+		var ids, ended = c.chain.generate(start, limit)
+		if ended && uint(len(ids)) >= minLen && uint(len(ids)) <= maxLen {
+			return c.detokenizer.Join(c.chain.symbols.symbols(ids))
+		}
+	}
+
+	return ""
+}
+
+// charTokenizer splits the text in the runes of every word, emitting an
+// EndToken after each of them. Tokens are joined back without separator.
+type charTokenizer struct{}
+
+// Split implements Tokenizer
+func (charTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if atEOF || utf8.FullRune(data) {
+		var r, width = utf8.DecodeRune(data)
+		if !unicode.IsSpace(r) {
+			return width, data[:width], nil
+		}
+	}
+
+	// skip the spaces and signal the end of the word. The window ignores
+	// empty words, so it's fine to emit extra EndTokens for leading spaces or
+	// spaces split across reads
+	var advance = 0
+	for advance < len(data) {
+		var r, width = utf8.DecodeRune(data[advance:])
+		if !unicode.IsSpace(r) || (!atEOF && !utf8.FullRune(data[advance:])) {
+			break
+		}
+		advance += width
+	}
+
+	if advance == 0 {
+		// request more data to complete the rune
+		return 0, nil, nil
+	}
+
+	return advance, []byte(EndToken), nil
+}
+
+// Join implements Detokenizer
+func (charTokenizer) Join(tokens []string) string {
+	return strings.Join(tokens, "")
+}
@@ -0,0 +1,223 @@
+package markov
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCharTokenizer_Split(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		text string
+
+		wantTokens []string
+	}{
+		{
+			name:       "ok - words",
+			text:       "ab añ",
+			wantTokens: []string{"a", "b", EndToken, "a", "ñ"},
+		},
+		{
+			name:       "ok - multiple spaces",
+			text:       "  ab \n\t c ",
+			wantTokens: []string{EndToken, "a", "b", EndToken, "c", EndToken},
+		},
+		{
+			name:       "ok - empty",
+			text:       "",
+			wantTokens: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var tokens = tokenize(charTokenizer{}, tt.text)
+			if !reflect.DeepEqual(tokens, tt.wantTokens) {
+				t.Errorf("got %q, want %q", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestCharChain_ProcessText(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		n    uint
+		text string
+
+		wantEntries []testEntry
+	}{
+		{
+			name: "ok - bigrams",
+			n:    2,
+			text: "ab",
+			wantEntries: []testEntry{
+				{prefix: []string{StartToken}, candidates: []testCandidate{{"a", 1}}},
+				{prefix: []string{"a"}, candidates: []testCandidate{{"b", 1}}},
+				{prefix: []string{"b"}, candidates: []testCandidate{{EndToken, 1}}},
+			},
+		},
+		{
+			name: "ok - trigrams",
+			n:    3,
+			text: " ab\na ",
+			wantEntries: []testEntry{
+				{prefix: []string{StartToken, StartToken}, candidates: []testCandidate{{"a", 2}}},
+				{prefix: []string{StartToken, "a"}, candidates: []testCandidate{{"b", 1}, {EndToken, 1}}},
+				{prefix: []string{"a", "b"}, candidates: []testCandidate{{EndToken, 1}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, err = NewCharChain(tt.n)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := chain.ProcessText(strings.NewReader(tt.text)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("got %v, want %v", entries, tt.wantEntries)
+			}
+
+			if len(chain.chain.seeds) != 0 {
+				t.Errorf("got %v, want no seeds", seedTokens(chain.chain))
+			}
+		})
+	}
+}
+
+func TestNGramChain_GenerateWord(t *testing.T) {
+	t.Parallel()
+
+	var getChain = func(randFunc func(int) int) *NGramChain {
+		var chain, _ = NewCharChain(3)
+		chain.ProcessText(strings.NewReader("ab a"))
+		chain.chain.randFunc = randFunc
+		return chain
+	}
+
+	var first = func(int) int { return 0 }
+	var last = func(n int) int { return n - 1 }
+
+	var tests = []struct {
+		name   string
+		chain  *NGramChain
+		minLen uint
+		maxLen uint
+
+		wantWord string
+	}{
+		{
+			name:     "ok - first candidates",
+			chain:    getChain(first),
+			minLen:   1,
+			maxLen:   5,
+			wantWord: "ab",
+		},
+		{
+			name:     "ok - last candidates",
+			chain:    getChain(last),
+			minLen:   1,
+			maxLen:   5,
+			wantWord: "a",
+		},
+		{
+			name:     "ok - exact length",
+			chain:    getChain(first),
+			minLen:   2,
+			maxLen:   2,
+			wantWord: "ab",
+		},
+		{
+			name:     "ok - no upper limit",
+			chain:    getChain(first),
+			minLen:   1,
+			maxLen:   math.MaxUint,
+			wantWord: "ab",
+		},
+		{
+			name:     "ok - too long",
+			chain:    getChain(first),
+			minLen:   1,
+			maxLen:   1,
+			wantWord: "",
+		},
+		{
+			name:     "ok - too short",
+			chain:    getChain(last),
+			minLen:   2,
+			maxLen:   5,
+			wantWord: "",
+		},
+		{
+			name:     "ok - invalid limits",
+			chain:    getChain(first),
+			minLen:   3,
+			maxLen:   2,
+			wantWord: "",
+		},
+		{
+			name: "ok - empty chain",
+			chain: func() *NGramChain {
+				var chain, _ = NewCharChain(3)
+				return chain
+			}(),
+			minLen:   1,
+			maxLen:   5,
+			wantWord: "",
+		},
+		{
+			name: "ok - unbounded chain",
+			chain: func() *NGramChain {
+				var chain, _ = NewNGramChain(3)
+				chain.ProcessText(strings.NewReader("a b c"))
+				return chain
+			}(),
+			minLen:   1,
+			maxLen:   5,
+			wantWord: "",
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var word = tt.chain.GenerateWord(tt.minLen, tt.maxLen)
+			if word != tt.wantWord {
+				t.Errorf("got %q, want %q", word, tt.wantWord)
+			}
+		})
+	}
+}
+
+func TestCharChain_GenerateRandomText(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewCharChain(2)
+	chain.ProcessText(strings.NewReader("abc"))
+
+	// generation starts at the beginning of the word and stops at its end
+	var text = chain.GenerateRandomText(100)
+	if text != "abc." {
+		t.Errorf("got %q, want %q", text, "abc.")
+	}
+}
@@ -0,0 +1,629 @@
+package markov
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// diskMagic identifies the files of a DiskStore
+var diskMagic = [4]byte{'M', 'K', 'V', 'D'}
+
+// diskVersion is the current version of the DiskStore layout
+const diskVersion = 1
+
+// diskHeaderSize is the length of the magic and version opening the file
+const diskHeaderSize = 8
+
+// the kinds of records of a DiskStore
+const (
+	symbolRecord byte = iota + 1
+	prefixRecord
+)
+
+// diskPendingLimit is the number of changed prefixes a DiskStore keeps in
+// memory before writing them
+const diskPendingLimit = 4096
+
+// DiskStore is a SymbolStore keeping the ngrams in a file, so chains larger
+// than memory can be trained and reopened later. The file is a log of records:
+// every symbol added, and the whole list of candidates of a prefix every time
+// it's written. Only the position of the last record of every prefix is kept in
+// memory, so looking a prefix up reads it from the file.
+//
+// The changed prefixes are kept in memory until there are enough of them, or
+// until Flush or Close are called. The log grows with every write, so it
+// should be compacted from time to time with Compact. A torn record at the end
+// of the file, usually left by a crash, is dropped when the store is opened,
+// but a corrupted record before it fails the opening, leaving the file as is.
+//
+// An error reading the file makes the chain see the prefix as missing, so it's
+// kept and returned by every Increment, Flush and Close from then on, and by
+// Err.
+type DiskStore struct {
+	path string
+	file *os.File
+	size int64
+
+	lock sync.RWMutex
+
+	// offsets holds the position of the last record of every key, or -1 for
+	// the keys which were never written. keys keeps them in insertion order
+	offsets map[string]int64
+	keys    []string
+	symbols []string
+
+	// pending holds the candidates of the keys changed since the last write,
+	// in the order they changed, and pendingSymbols the symbols added since
+	// then
+	pending        map[string]*candidates
+	pendingKeys    []string
+	pendingSymbols []string
+
+	// err is the last write error, returned by the next Increment
+	err error
+
+	// readErr is the first error reading the file. It's guarded by its own
+	// lock, since reads only hold the read lock
+	readErr error
+	errLock sync.Mutex
+}
+
+// OpenDiskStore opens the DiskStore at the given path, creating it if it
+// doesn't exist, to be used by a chain with WithStore. The store must be
+// closed once the chain is not used anymore.
+func OpenDiskStore(path string) (*DiskStore, error) {
+	var file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening disk store: %w", err)
+	}
+
+	var store = &DiskStore{
+		path:    path,
+		file:    file,
+		offsets: make(map[string]int64),
+		pending: make(map[string]*candidates),
+	}
+
+	if err := store.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error opening disk store: %w", err)
+	}
+
+	return store, nil
+}
+
+// replay reads the whole log, writing the header of new files and dropping
+// the last record if it's torn. Any other invalid record fails the replay
+func (s *DiskStore) replay() error {
+	var info, err = s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		var header = diskHeader()
+		if _, err := s.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+		s.size = int64(len(header))
+		return nil
+	}
+
+	var header = make([]byte, diskHeaderSize)
+	if _, err := s.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+
+	if !bytes.Equal(header[:len(diskMagic)], diskMagic[:]) {
+		return fmt.Errorf("%w: not a disk store", ErrInvalidFormat)
+	}
+
+	if version := binary.LittleEndian.Uint32(header[len(diskMagic):]); version == 0 || version > diskVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	var r = bufio.NewReader(io.NewSectionReader(s.file, diskHeaderSize, info.Size()-diskHeaderSize))
+	var offset = int64(diskHeaderSize)
+	for {
+		var kind, payload, n, err = readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+
+		// a record cut by the end of the file, or the last one with the
+		// wrong checksum, was being written when the store stopped
+		var torn = errors.Is(err, errTornRecord) || (errors.Is(err, ErrChecksumMismatch) && offset+n == info.Size())
+		if torn {
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		if err == nil {
+			err = s.apply(kind, payload, offset)
+		}
+
+		if err != nil {
+			return fmt.Errorf("record at %d: %w", offset, err)
+		}
+
+		offset += n
+	}
+
+	s.size = offset
+
+	return nil
+}
+
+// apply adds the record at the given offset to the in memory state
+func (s *DiskStore) apply(kind byte, payload []byte, offset int64) error {
+	switch kind {
+	case symbolRecord:
+		s.symbols = append(s.symbols, string(payload))
+		return nil
+	case prefixRecord:
+		var key, _, err = decodePrefixRecord(payload)
+		if err != nil {
+			return err
+		}
+
+		if _, exists := s.offsets[key]; !exists {
+			s.keys = append(s.keys, key)
+		}
+		s.offsets[key] = offset
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown record kind %d", ErrInvalidFormat, kind)
+	}
+}
+
+// Increment implements Store
+func (s *DiskStore) Increment(prefix []uint32, candidate uint32, frequency int) (bool, error) {
+	return s.increment(packKey(prefix), candidate, frequency)
+}
+
+// Candidates implements Store
+func (s *DiskStore) Candidates(prefix []uint32) ([]Candidate, bool) {
+	return storeCandidates(s, prefix)
+}
+
+// Range implements Store, walking the prefixes in insertion order
+func (s *DiskStore) Range(f func(prefix []uint32, candidates []Candidate) bool) {
+	rangeStore(s, f)
+}
+
+// Len implements Store
+func (s *DiskStore) Len() int {
+	return s.len()
+}
+
+// Symbols implements SymbolStore
+func (s *DiskStore) Symbols() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]string(nil), s.symbols...)
+}
+
+// AddSymbol implements SymbolStore
+func (s *DiskStore) AddSymbol(symbol string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.symbols = append(s.symbols, symbol)
+	s.pendingSymbols = append(s.pendingSymbols, symbol)
+}
+
+// Flush writes the changes kept in memory to the file. It returns the error
+// reading the file, if any, even if the changes were written
+func (s *DiskStore) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err = s.flush()
+	if err == nil {
+		err = s.Err()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error flushing disk store: %w", err)
+	}
+
+	return nil
+}
+
+// Err returns the first error reading the file, which made the chain see a
+// prefix as missing, or nil if there was none
+func (s *DiskStore) Err() error {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+
+	return s.readErr
+}
+
+// failRead keeps the first error reading the file
+func (s *DiskStore) failRead(err error) {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+
+	if s.readErr == nil {
+		s.readErr = err
+	}
+}
+
+// Close flushes the store and closes its file. The chain using the store can't
+// be used afterwards
+func (s *DiskStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err = s.flush()
+	if err == nil {
+		err = s.file.Sync()
+	}
+
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = s.Err()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error closing disk store: %w", err)
+	}
+
+	return nil
+}
+
+// Compact rewrites the file with only the last record of every prefix, so it
+// doesn't keep growing with every write
+func (s *DiskStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.compact(); err != nil {
+		return fmt.Errorf("error compacting disk store: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DiskStore) compact() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	// write the compacted log aside, so a crash leaves either of them whole
+	var tmpPath = s.path + ".tmp"
+	var offsets, size, err = s.writeCompacted(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	var file *os.File
+	if file, err = os.OpenFile(s.path, os.O_RDWR, 0); err != nil {
+		return err
+	}
+
+	s.file.Close()
+	s.file = file
+	s.size = size
+	s.offsets = offsets
+
+	return nil
+}
+
+// flush writes the pending symbols, followed by the pending prefixes, so every
+// prefix in the file only refers to symbols before it. The caller must hold
+// the lock
+func (s *DiskStore) flush() error {
+	if len(s.pending) == 0 && len(s.pendingSymbols) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, symbol := range s.pendingSymbols {
+		buf = appendRecord(buf, symbolRecord, []byte(symbol))
+	}
+
+	// new keys are written in insertion order, so they're replayed in the
+	// same order
+	var keys = s.pendingKeys
+	var offsets = make([]int64, len(keys))
+	for i, key := range keys {
+		offsets[i] = s.size + int64(len(buf))
+		buf = appendRecord(buf, prefixRecord, encodePrefixRecord(key, s.pending[key]))
+	}
+
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		s.err = err
+		return err
+	}
+
+	for i, key := range keys {
+		s.offsets[key] = offsets[i]
+	}
+
+	s.size += int64(len(buf))
+	s.pending = make(map[string]*candidates)
+	s.pendingKeys = nil
+	s.pendingSymbols = nil
+	s.err = nil
+
+	return nil
+}
+
+// read returns the candidates of the prefix record at the given offset
+func (s *DiskStore) read(offset int64) (*candidates, error) {
+	var r = bufio.NewReader(io.NewSectionReader(s.file, offset, s.size-offset))
+	var kind, payload, _, err = readRecord(r, s.size-offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind != prefixRecord {
+		return nil, fmt.Errorf("%w: record at %d is not a prefix", ErrInvalidFormat, offset)
+	}
+
+	var _, candidates, decodeErr = decodePrefixRecord(payload)
+	return candidates, decodeErr
+}
+
+func (s *DiskStore) get(key string) (*candidates, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if candidates, exists := s.pending[key]; exists {
+		return candidates, true
+	}
+
+	var offset, exists = s.offsets[key]
+	if !exists {
+		return nil, false
+	}
+
+	var candidates, err = s.read(offset)
+	if err != nil {
+		s.failRead(fmt.Errorf("error reading prefix at %d: %w", offset, err))
+		return nil, false
+	}
+
+	return candidates, true
+}
+
+func (s *DiskStore) increment(key string, candidate uint32, frequency int) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return false, s.err
+	}
+
+	if err := s.Err(); err != nil {
+		return false, err
+	}
+
+	var values, pending = s.pending[key]
+	var offset, exists = s.offsets[key]
+	if !pending {
+		values = &candidates{}
+		if exists {
+			var err error
+			if values, err = s.read(offset); err != nil {
+				err = fmt.Errorf("error reading prefix at %d: %w", offset, err)
+				s.failRead(err)
+				return false, err
+			}
+		} else {
+			s.offsets[key] = -1
+			s.keys = append(s.keys, key)
+		}
+		s.pending[key] = values
+		s.pendingKeys = append(s.pendingKeys, key)
+	}
+
+	values.addCandidate(candidate, frequency)
+
+	if len(s.pending) >= diskPendingLimit {
+		if err := s.flush(); err != nil {
+			return !exists, err
+		}
+	}
+
+	return !exists, nil
+}
+
+func (s *DiskStore) len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.keys)
+}
+
+func (s *DiskStore) entry(i int) (string, *candidates) {
+	s.lock.RLock()
+	var key = s.keys[i]
+	s.lock.RUnlock()
+
+	var candidates, _ = s.get(key)
+	return key, candidates
+}
+
+func (s *DiskStore) each(f func(key string, candidates *candidates) bool) {
+	for i := 0; i < s.len(); i++ {
+		// the walk stops at the first prefix which can't be read back, see
+		// Err
+		if key, candidates := s.entry(i); candidates == nil || !f(key, candidates) {
+			return
+		}
+	}
+}
+
+func (s *DiskStore) empty() ngramStore {
+	return nil
+}
+
+// diskHeader returns the header opening every DiskStore file
+func diskHeader() []byte {
+	var header = append([]byte(nil), diskMagic[:]...)
+	return binary.LittleEndian.AppendUint32(header, diskVersion)
+}
+
+// appendRecord appends a record to buf: the length of the kind and payload,
+// followed by them and their CRC-32 (IEEE), as little endian uint32
+func appendRecord(buf []byte, kind byte, payload []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(1+len(payload)))
+
+	var start = len(buf)
+	buf = append(buf, kind)
+	buf = append(buf, payload...)
+
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+}
+
+// errTornRecord is returned when a record is cut by the end of the file
+var errTornRecord = fmt.Errorf("%w: torn record", ErrInvalidFormat)
+
+// readRecord reads the next record, with at most remaining bytes left in the
+// file. It returns io.EOF if there are no more records, and the length of the
+// record read, even if its checksum doesn't match
+func readRecord(r *bufio.Reader, remaining int64) (byte, []byte, int64, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, 0, io.EOF
+		}
+		return 0, nil, 0, errTornRecord
+	}
+
+	var length = int64(binary.LittleEndian.Uint32(prefix[:]))
+	if length == 0 {
+		return 0, nil, 0, fmt.Errorf("%w: empty record", ErrInvalidFormat)
+	}
+
+	if 4+length+4 > remaining {
+		return 0, nil, 0, errTornRecord
+	}
+
+	var body = make([]byte, length+4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, 0, errTornRecord
+	}
+
+	if crc32.ChecksumIEEE(body[:length]) != binary.LittleEndian.Uint32(body[length:]) {
+		return 0, nil, 4 + length + 4, ErrChecksumMismatch
+	}
+
+	return body[0], body[1:length], 4 + length + 4, nil
+}
+
+// encodePrefixRecord encodes the key and its candidates as the payload of a
+// prefix record: the number of IDs of the key followed by them, and the number
+// of candidates followed by every ID and frequency, as little endian integers
+func encodePrefixRecord(key string, candidates *candidates) []byte {
+	var payload = make([]byte, 0, 4+len(key)+4+12*len(candidates.words))
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(key)/idSize))
+	payload = append(payload, key...)
+
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(candidates.words)))
+	for _, wf := range candidates.words {
+		payload = binary.LittleEndian.AppendUint32(payload, wf.word)
+		payload = binary.LittleEndian.AppendUint64(payload, uint64(wf.frequency))
+	}
+
+	return payload
+}
+
+// decodePrefixRecord decodes the payload of a prefix record
+func decodePrefixRecord(payload []byte) (string, *candidates, error) {
+	var errInvalid = fmt.Errorf("%w: invalid prefix record", ErrInvalidFormat)
+
+	if len(payload) < 4 {
+		return "", nil, errInvalid
+	}
+
+	var keySize = int64(binary.LittleEndian.Uint32(payload)) * idSize
+	if int64(len(payload)) < 4+keySize+4 {
+		return "", nil, errInvalid
+	}
+
+	var key = string(payload[4 : 4+keySize])
+	var rest = payload[4+keySize:]
+
+	var count = int64(binary.LittleEndian.Uint32(rest))
+	if int64(len(rest)) != 4+12*count {
+		return "", nil, errInvalid
+	}
+
+	var candidates = &candidates{}
+	for i := int64(0); i < count; i++ {
+		var raw = rest[4+12*i:]
+		candidates.addCandidate(binary.LittleEndian.Uint32(raw), int(binary.LittleEndian.Uint64(raw[4:])))
+	}
+
+	return key, candidates, nil
+}
+
+// writeCompacted streams the symbols and the last record of every key to a new
+// file at the given path, syncing it to disk before closing it. It returns the
+// offset of every key record and the size of the file. The caller must hold the
+// lock
+func (s *DiskStore) writeCompacted(path string) (map[string]int64, int64, error) {
+	var file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the write errors are kept by the writer and returned by Flush
+	var bw = bufio.NewWriter(file)
+	var record = diskHeader()
+	bw.Write(record)
+
+	var size = int64(len(record))
+	for _, symbol := range s.symbols {
+		record = appendRecord(record[:0], symbolRecord, []byte(symbol))
+		bw.Write(record)
+		size += int64(len(record))
+	}
+
+	var offsets = make(map[string]int64, len(s.keys))
+	for _, key := range s.keys {
+		var candidates, err = s.read(s.offsets[key])
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+
+		offsets[key] = size
+		record = appendRecord(record[:0], prefixRecord, encodePrefixRecord(key, candidates))
+		bw.Write(record)
+		size += int64(len(record))
+	}
+
+	if err := bw.Flush(); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return offsets, size, file.Close()
+}
@@ -0,0 +1,237 @@
+package markov
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiskStore(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "chain.db")
+
+	var want, _ = NewNGramChain(3, WithSeed(1))
+	want.ProcessText(strings.NewReader(smoothingText))
+
+	var store, err = OpenDiskStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var chain, _ = NewNGramChain(3, WithSeed(1), WithStore(store))
+	if err := chain.ProcessText(strings.NewReader(smoothingText)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+		t.Errorf("got %v, want %v", entries, storeEntries(want.chain))
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the reopened chain has the same ngrams, vocabulary and seeds
+	var reopened, openErr = OpenDiskStore(path)
+	if openErr != nil {
+		t.Fatalf("unexpected error: %v", openErr)
+	}
+	defer reopened.Close()
+
+	var restored, _ = NewNGramChain(3, WithSeed(1), WithStore(reopened))
+	if entries := storeEntries(restored.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+		t.Errorf("got %v, want %v", entries, storeEntries(want.chain))
+	}
+
+	if symbols := restored.Vocab().Symbols(); !reflect.DeepEqual(symbols, want.Vocab().Symbols()) {
+		t.Errorf("got %v, want %v", symbols, want.Vocab().Symbols())
+	}
+
+	if seeds := seedTokens(restored.chain); !reflect.DeepEqual(seeds, seedTokens(want.chain)) {
+		t.Errorf("got %v, want %v", seeds, seedTokens(want.chain))
+	}
+
+	if text := restored.GenerateRandomText(20); text != want.GenerateRandomText(20) {
+		t.Errorf("got %q, want the same text as the map store", text)
+	}
+
+	// training can go on after reopening, and compacting keeps the ngrams
+	var more = "I am the law. You are groot."
+	want.ProcessText(strings.NewReader(more))
+	if err := restored.ProcessText(strings.NewReader(more)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := reopened.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var before, _ = os.Stat(path)
+
+	if err := reopened.Compact(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var after, _ = os.Stat(path)
+
+	if after.Size() >= before.Size() {
+		t.Errorf("got %v, want less than %v", after.Size(), before.Size())
+	}
+
+	if reopened.size != after.Size() {
+		t.Errorf("got %v, want %v", reopened.size, after.Size())
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("got %v, want the compacted log renamed", err)
+	}
+
+	if entries := storeEntries(restored.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+		t.Errorf("got %v, want %v", entries, storeEntries(want.chain))
+	}
+
+	// the store can't be replaced by a loaded chain
+	var buf bytes.Buffer
+	want.Save(&buf)
+	if err := restored.Load(&buf); err == nil {
+		t.Errorf("got %v, want an error", err)
+	}
+}
+
+func TestDiskStore_tornRecord(t *testing.T) {
+	t.Parallel()
+
+	var record = appendRecord(nil, prefixRecord, encodePrefixRecord(packKey([]uint32{firstSymbolID}), &candidates{}))
+
+	var tests = []struct {
+		name string
+		torn []byte
+	}{
+		{name: "cut record", torn: record[:len(record)-1]},
+		{
+			name: "garbled record",
+			torn: func() []byte {
+				var garbled = slices.Clone(record)
+				garbled[len(garbled)/2] ^= 0xff
+				return garbled
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var path = filepath.Join(t.TempDir(), "chain.db")
+
+			var store, _ = OpenDiskStore(path)
+			var chain, _ = NewNGramChain(2, WithStore(store))
+			chain.ProcessText(strings.NewReader("I am batman."))
+			var want = storeEntries(chain.chain)
+			store.Close()
+
+			// a crash in the middle of a write leaves part of a record
+			var file, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			file.Write(tt.torn)
+			file.Close()
+
+			var reopened, err = OpenDiskStore(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer reopened.Close()
+
+			var restored, _ = NewNGramChain(2, WithStore(reopened))
+			if entries := storeEntries(restored.chain); !reflect.DeepEqual(entries, want) {
+				t.Errorf("got %v, want %v", entries, want)
+			}
+		})
+	}
+}
+
+func TestDiskStore_corruptedRecord(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "chain.db")
+
+	var store, _ = OpenDiskStore(path)
+	var chain, _ = NewNGramChain(2, WithStore(store))
+	chain.ProcessText(strings.NewReader("I am batman."))
+	store.Close()
+	var offset = store.offsets[store.keys[0]]
+
+	// the records after a corrupted one are valid, so it can't be torn
+	var file, _ = os.OpenFile(path, os.O_RDWR, 0)
+	file.WriteAt([]byte{0xff}, offset+8)
+	file.Close()
+	var before, _ = os.Stat(path)
+
+	if _, err := OpenDiskStore(path); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Errorf("got %v, want %v", after.Size(), before.Size())
+	}
+}
+
+func TestDiskStore_readError(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "chain.db")
+
+	var store, _ = OpenDiskStore(path)
+	defer store.Close()
+
+	var chain, _ = NewNGramChain(2, WithStore(store))
+	chain.ProcessText(strings.NewReader("I am batman."))
+	store.Flush()
+
+	// the prefix is corrupted while the store is open
+	var file, _ = os.OpenFile(path, os.O_RDWR, 0)
+	file.WriteAt([]byte{0xff}, store.offsets[store.keys[0]]+8)
+	file.Close()
+
+	if err := chain.Save(io.Discard); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	// the error is kept, rather than seeing the prefix as missing
+	if err := store.Err(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	if err := chain.ProcessText(strings.NewReader("I am groot.")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	if err := store.Flush(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestDiskStore_errors(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+
+	var invalid = filepath.Join(dir, "invalid.db")
+	os.WriteFile(invalid, []byte("not a disk store"), 0o644)
+	if _, err := OpenDiskStore(invalid); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("got %v, want %v", err, ErrInvalidFormat)
+	}
+
+	var store, _ = OpenDiskStore(filepath.Join(dir, "ints.db"))
+	defer store.Close()
+
+	var wantErr = errors.New("error initialising Chain: symbol stores need a chain of strings")
+	if _, err := NewChain[int](2, WithStore(store)); err == nil || err.Error() != wantErr.Error() {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+}
@@ -0,0 +1,279 @@
+package markov
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Progress reports how far ProcessTexts or ProcessFiles got
+type Progress struct {
+	// Texts is the number of texts processed out of Total
+	Texts int
+	Total int
+
+	// Tokens is the number of tokens read from the processed texts
+	Tokens int
+}
+
+// IngestOption configures ProcessTexts and ProcessFiles
+type IngestOption func(*ingestConfig)
+
+// ingestConfig holds the settings of a bulk ingestion, as set by its options
+type ingestConfig struct {
+	workers  int
+	progress func(Progress)
+}
+
+// WithWorkers sets the number of goroutines processing the texts at the same
+// time. It defaults to the number of CPUs
+func WithWorkers(workers int) IngestOption {
+	return func(c *ingestConfig) {
+		c.workers = workers
+	}
+}
+
+// WithProgress calls the given function every time a text is processed. The
+// calls never overlap, so it doesn't need to be safe for concurrent use
+func WithProgress(progress func(Progress)) IngestOption {
+	return func(c *ingestConfig) {
+		c.progress = progress
+	}
+}
+
+// ingestTableSize is the number of different ngrams a worker counts before
+// merging them into the chain
+const ingestTableSize = 1 << 16
+
+// ProcessTexts processes the texts with a pool of workers, as ProcessText
+// would one after the other. Every worker counts the ngrams of its texts on
+// its own and merges the counts into the chain in batches, so the workers
+// rarely wait for each other. The ngrams are the same as if the texts were
+// processed one after the other, but they are added in a different order.
+//
+// The first error, or the cancellation of the context, stops all the workers
+// and is returned. The ngrams merged before that are kept in the chain. The
+// context being done once every text was processed is not an error.
+func (c *NGramChain) ProcessTexts(ctx context.Context, texts []io.Reader, opts ...IngestOption) error {
+	return c.ingest(ctx, len(texts), func(i int) (io.ReadCloser, error) {
+		return io.NopCloser(texts[i]), nil
+	}, opts)
+}
+
+// ProcessFiles processes the files at the given paths like ProcessTexts. The
+// files are only opened when a worker gets to them
+func (c *NGramChain) ProcessFiles(ctx context.Context, paths []string, opts ...IngestOption) error {
+	return c.ingest(ctx, len(paths), func(i int) (io.ReadCloser, error) {
+		var file, err = os.Open(paths[i])
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", paths[i], err)
+		}
+
+		return file, nil
+	}, opts)
+}
+
+// ingest processes the total texts returned by open with a pool of workers
+func (c *NGramChain) ingest(ctx context.Context, total int, open func(i int) (io.ReadCloser, error), opts []IngestOption) error {
+	var cfg = &ingestConfig{workers: runtime.GOMAXPROCS(0)}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	cfg.workers = max(1, min(cfg.workers, total))
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var jobs = make(chan int)
+	var progress = &ingestProgress{report: cfg.progress, progress: Progress{Total: total}}
+
+	// failure keeps the first error of the workers, so the cancellation of
+	// the caller context once all the texts were processed isn't one
+	var failure error
+	var failureOnce sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := c.ingestWorker(ctx, jobs, open, progress); err != nil {
+				if err == ctx.Err() {
+					err = context.Cause(ctx)
+				}
+
+				failureOnce.Do(func() { failure = err })
+				cancel(err)
+			}
+		}()
+	}
+
+	// hand out the texts until they're over or a worker fails
+	var handedOut = func() bool {
+		defer close(jobs)
+
+		for i := 0; i < total; i++ {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		return true
+	}()
+
+	wg.Wait()
+
+	if failure != nil {
+		return failure
+	}
+
+	// the context was done before the texts were handed out
+	if !handedOut {
+		return context.Cause(ctx)
+	}
+
+	return nil
+}
+
+// ingestWorker processes the texts on jobs, counting their ngrams in its own
+// table and merging it into the chain whenever it's full and once the jobs
+// are over
+func (c *NGramChain) ingestWorker(ctx context.Context, jobs <-chan int, open func(i int) (io.ReadCloser, error), progress *ingestProgress) error {
+	var table = newNgramTable()
+
+	for i := range jobs {
+		var tokens, err = c.ingestText(ctx, i, open, table)
+		if err != nil {
+			return err
+		}
+
+		progress.add(tokens)
+	}
+
+	return c.chain.merge(table)
+}
+
+// ingestText counts the ngrams of the i-th text in the table, merging it into
+// the chain whenever it's full. It returns the number of tokens read
+func (c *NGramChain) ingestText(ctx context.Context, i int, open func(i int) (io.ReadCloser, error), table *ngramTable) (int, error) {
+	var text, err = open(i)
+	if err != nil {
+		return 0, err
+	}
+	defer text.Close()
+
+	var window = c.chain.newWindow()
+	window.process = func(ngram []uint32) error {
+		if table.count(ngram) < ingestTableSize {
+			return nil
+		}
+
+		return c.chain.merge(table)
+	}
+
+	return c.processTokens(ctx, text, window)
+}
+
+// ingestProgress counts the texts processed by the workers and reports them
+type ingestProgress struct {
+	lock     sync.Mutex
+	report   func(Progress)
+	progress Progress
+}
+
+// add records a processed text and its tokens, and reports the progress
+func (p *ingestProgress) add(tokens int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.progress.Texts++
+	p.progress.Tokens += tokens
+
+	if p.report != nil {
+		p.report(p.progress)
+	}
+}
+
+// ngramTable counts ngrams by their packed IDs, keeping the order they were
+// first seen in
+type ngramTable struct {
+	counts map[string]int
+	keys   []string
+
+	// buf holds the ngram being counted, so counting a known ngram doesn't
+	// allocate
+	buf []byte
+}
+
+func newNgramTable() *ngramTable {
+	return &ngramTable{counts: make(map[string]int)}
+}
+
+// count adds an occurrence of the ngram to the table, and returns the number
+// of different ngrams in it
+func (t *ngramTable) count(ngram []uint32) int {
+	t.buf = t.buf[:0]
+	for _, id := range ngram {
+		t.buf = binary.LittleEndian.AppendUint32(t.buf, id)
+	}
+
+	if count, exists := t.counts[string(t.buf)]; exists {
+		t.counts[string(t.buf)] = count + 1
+	} else {
+		var key = string(t.buf)
+		t.counts[key] = 1
+		t.keys = append(t.keys, key)
+	}
+
+	return len(t.keys)
+}
+
+// len returns the number of different ngrams in the table
+func (t *ngramTable) len() int {
+	return len(t.keys)
+}
+
+// reset empties the table
+func (t *ngramTable) reset() {
+	clear(t.counts)
+	t.keys = t.keys[:0]
+}
+
+// merge adds the ngrams of the table to the chain, in the order they were
+// first seen, and empties the table
+func (c *Chain[T]) merge(table *ngramTable) error {
+	if table.len() == 0 {
+		return nil
+	}
+
+	if c.striped() {
+		c.lock.lockShared()
+		defer c.lock.unlockShared()
+	} else {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	var ngram = make([]uint32, 0, c.n)
+	for _, key := range table.keys {
+		ngram = ngram[:0]
+		for i := 0; i < len(key); i += idSize {
+			ngram = append(ngram, keyID(key, i))
+		}
+
+		if err := c.addNgram(ngram, table.counts[key]); err != nil {
+			return err
+		}
+	}
+	table.reset()
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package markov
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ingestTexts returns the documents processed by the bulk ingestion tests
+func ingestTexts() []string {
+	var texts = strings.Split(smoothingText, ".")
+	for i := 0; i < 20; i++ {
+		texts = append(texts, fmt.Sprintf("It's document %d of the corpus, I am batman %d.", i, i%3))
+	}
+
+	return texts
+}
+
+// sortCandidates sorts the candidates of every entry by word, since they
+// depend on the order the ngrams were added in
+func sortCandidates(entries []testEntry) []testEntry {
+	for _, entry := range entries {
+		sort.Slice(entry.candidates, func(i, j int) bool {
+			return entry.candidates[i].word < entry.candidates[j].word
+		})
+	}
+
+	return entries
+}
+
+func TestNGramChain_ProcessTexts(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name    string
+		opts    func() []Option
+		workers int
+
+		// with one worker the ngrams are added in the same order as
+		// ProcessText would
+		ordered bool
+	}{
+		{name: "one worker", workers: 1, ordered: true},
+		{
+			name:    "one worker with backoff",
+			opts:    func() []Option { return []Option{WithBackoff(KatzBackoff())} },
+			workers: 1,
+			ordered: true,
+		},
+		{
+			name:    "one worker with sentence boundaries",
+			opts:    func() []Option { return []Option{WithSentenceBoundaries()} },
+			workers: 1,
+			ordered: true,
+		},
+		{name: "several workers", workers: 4},
+		{
+			name:    "several workers with backoff",
+			opts:    func() []Option { return []Option{WithBackoff(KatzBackoff())} },
+			workers: 4,
+		},
+		{
+			name:    "several workers with a sharded store",
+			opts:    func() []Option { return []Option{WithStore(NewShardedStore(0))} },
+			workers: 4,
+		},
+		{name: "more workers than texts", workers: 100},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var newChain = func() *NGramChain {
+				var opts []Option
+				if tt.opts != nil {
+					opts = tt.opts()
+				}
+
+				var chain, _ = NewNGramChain(3, append(opts, WithSeed(1))...)
+				return chain
+			}
+
+			var want, chain = newChain(), newChain()
+
+			var texts []io.Reader
+			for _, text := range ingestTexts() {
+				want.ProcessText(strings.NewReader(text))
+				texts = append(texts, strings.NewReader(text))
+			}
+
+			var reports []Progress
+			var err = chain.ProcessTexts(context.Background(), texts, WithWorkers(tt.workers), WithProgress(func(p Progress) {
+				reports = append(reports, p)
+			}))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.ordered {
+				if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+					t.Errorf("got %v, want %v", entries, storeEntries(want.chain))
+				}
+
+				if seeds := seedTokens(chain.chain); !reflect.DeepEqual(seeds, seedTokens(want.chain)) {
+					t.Errorf("got %v, want %v", seeds, seedTokens(want.chain))
+				}
+			}
+
+			var entries, wantEntries = sortCandidates(sortedEntries(chain.chain)), sortCandidates(sortedEntries(want.chain))
+			if !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", entries, wantEntries)
+			}
+
+			var lower, wantLower = sortCandidates(lowerEntries(chain.chain)), sortCandidates(lowerEntries(want.chain))
+			if !reflect.DeepEqual(lower, wantLower) {
+				t.Errorf("got %v, want %v", lower, wantLower)
+			}
+
+			var tokens = 0
+			for _, text := range ingestTexts() {
+				tokens += len(strings.Fields(text))
+			}
+
+			var wantReport = Progress{Texts: len(texts), Total: len(texts), Tokens: tokens}
+			if len(reports) != len(texts) || reports[len(reports)-1] != wantReport {
+				t.Errorf("got %v, want %v reports ending with %v", reports, len(texts), wantReport)
+			}
+		})
+	}
+}
+
+func TestNGramChain_ProcessFiles(t *testing.T) {
+	t.Parallel()
+
+	var dir = t.TempDir()
+
+	var want, _ = NewNGramChain(2)
+	var paths []string
+	for i, text := range ingestTexts() {
+		var path = filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		os.WriteFile(path, []byte(text), 0o644)
+		paths = append(paths, path)
+
+		want.ProcessText(strings.NewReader(text))
+	}
+
+	var chain, _ = NewNGramChain(2)
+	if err := chain.ProcessFiles(context.Background(), paths, WithWorkers(1)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+		t.Errorf("got %v, want %v", entries, storeEntries(want.chain))
+	}
+
+	// the first error stops the ingestion
+	var missing = append([]string{filepath.Join(dir, "missing.txt")}, paths...)
+	var failed, _ = NewNGramChain(2)
+	if err := failed.ProcessFiles(context.Background(), missing, WithWorkers(2)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestNGramChain_ProcessTexts_errors(t *testing.T) {
+	t.Parallel()
+
+	var canceled, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	var path = filepath.Join(t.TempDir(), "chain.mkvm")
+	var trained, _ = NewNGramChain(2)
+	var file, _ = os.Create(path)
+	trained.SaveMapped(file)
+	file.Close()
+
+	var readOnly, _ = OpenMappedStore(path)
+	defer readOnly.Close()
+
+	var tests = []struct {
+		name string
+		ctx  context.Context
+		opts []Option
+
+		wantErr error
+	}{
+		{
+			name:    "error - canceled context",
+			ctx:     canceled,
+			wantErr: context.Canceled,
+		},
+		{
+			name:    "error - read only store",
+			ctx:     context.Background(),
+			opts:    []Option{WithStore(readOnly)},
+			wantErr: ErrReadOnly,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(2, tt.opts...)
+
+			var texts []io.Reader
+			for _, text := range ingestTexts() {
+				texts = append(texts, strings.NewReader(text))
+			}
+
+			if err := chain.ProcessTexts(tt.ctx, texts, WithWorkers(2)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNGramChain_ProcessTexts_canceledAfterwards(t *testing.T) {
+	t.Parallel()
+
+	var want, _ = NewNGramChain(2)
+	var chain, _ = NewNGramChain(2)
+
+	var texts []io.Reader
+	for _, text := range ingestTexts() {
+		want.ProcessText(strings.NewReader(text))
+		texts = append(texts, strings.NewReader(text))
+	}
+
+	// the context is canceled once every text was processed
+	var ctx, cancel = context.WithCancel(context.Background())
+	var err = chain.ProcessTexts(ctx, texts, WithWorkers(4), WithProgress(func(p Progress) {
+		if p.Texts == p.Total {
+			cancel()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var entries, wantEntries = sortCandidates(sortedEntries(chain.chain)), sortCandidates(sortedEntries(want.chain))
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+}
+
+func BenchmarkNGramChain_ProcessTexts(b *testing.B) {
+	var corpus = strings.Fields(getBenchmarkCorpus())[:benchmarkCorpusTokens/4]
+	var documents []string
+	for i := 0; i < len(corpus); i += 1000 {
+		documents = append(documents, strings.Join(corpus[i:i+1000], " "))
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var texts = make([]io.Reader, len(documents))
+				for j, document := range documents {
+					texts[j] = strings.NewReader(document)
+				}
+
+				var chain, _ = NewNGramChain(3)
+				chain.ProcessTexts(context.Background(), texts, WithWorkers(workers))
+			}
+		})
+	}
+}
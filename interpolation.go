@@ -0,0 +1,234 @@
+package markov
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+const (
+	// emIterations is the maximum number of iterations run to fit the lambdas
+	emIterations = 100
+	// emTolerance is the change of the lambdas below which they're considered
+	// fitted
+	emTolerance = 1e-6
+)
+
+// Interpolation returns a Jelinek-Mercer interpolation, to be used with
+// WithBackoff. It mixes the relative frequencies of the word after every
+// suffix of the prefix, from the empty one (the unigrams) to the whole n-1
+// symbols prefix, weighted by the lambdas. There must be one lambda for every
+// order, from 1 to n, non-negative and adding up to 1. Without lambdas, every
+// order gets the same weight. The suffixes the chain doesn't know are left out
+// of the mixture, scaling up the weight of the rest, so the probabilities
+// always add up to 1 over the known symbols.
+//
+// The lambdas can be fitted on a held-out text with
+// NGramChain.FitInterpolation.
+func Interpolation(lambdas ...float64) Backoff {
+	return &interpolation{lambdas: slices.Clone(lambdas)}
+}
+
+// interpolation holds the weight of every order, indexed by the length of the
+// prefix. Its lambdas are guarded by the chain lock, since they can be fitted
+type interpolation struct {
+	lambdas []float64
+}
+
+// forOrder returns a copy of the interpolation for a chain of order n, checking
+// its lambdas or giving the same weight to every order if there's none
+func (b *interpolation) forOrder(n uint) (*interpolation, error) {
+	if len(b.lambdas) == 0 {
+		return &interpolation{lambdas: uniformLambdas(int(n))}, nil
+	}
+
+	if len(b.lambdas) != int(n) {
+		return nil, fmt.Errorf("interpolation needs %d lambdas, got %d", n, len(b.lambdas))
+	}
+
+	var sum = 0.0
+	for _, lambda := range b.lambdas {
+		if lambda < 0 {
+			return nil, errors.New("interpolation lambdas can't be negative")
+		}
+		sum += lambda
+	}
+
+	if math.Abs(sum-1) > 1e-9 {
+		return nil, fmt.Errorf("interpolation lambdas must add up to 1, got %v", sum)
+	}
+
+	return &interpolation{lambdas: slices.Clone(b.lambdas)}, nil
+}
+
+func (b *interpolation) probability(lookup func(key string) (*candidates, bool), stats *ngramStats, key string, word uint32) float64 {
+	var probabilities, exists = orderProbabilities(lookup, stats.minCount, key, word, len(b.lambdas))
+	return mixture(b.lambdas, probabilities, exists)
+}
+
+// orderProbabilities returns the relative frequency of the word after every
+// suffix of the key, from the empty one up to n-1 symbols, and whether each
+// suffix exists
+func orderProbabilities(lookup func(key string) (*candidates, bool), minCount int, key string, word uint32, n int) ([]float64, []bool) {
+	var probabilities, exists = make([]float64, n), make([]bool, n)
+	for k := 0; k < n && idSize*k <= len(key); k++ {
+		var candidates, found = lookup(key[len(key)-idSize*k:])
+		if !found {
+			continue
+		}
+
+		exists[k] = true
+		probabilities[k] = candidates.probability(word, minCount)
+	}
+
+	return probabilities, exists
+}
+
+// mixture returns the interpolated probability of the orders that exist,
+// scaling their lambdas so they add up to 1
+func mixture(lambdas, probabilities []float64, exists []bool) float64 {
+	var probability, weight = 0.0, 0.0
+	for k, lambda := range lambdas {
+		if exists[k] {
+			probability += lambda * probabilities[k]
+			weight += lambda
+		}
+	}
+
+	if weight == 0 {
+		return 0
+	}
+
+	return probability / weight
+}
+
+// uniformLambdas returns n lambdas with the same weight
+func uniformLambdas(n int) []float64 {
+	var lambdas = make([]float64, n)
+	for i := range lambdas {
+		lambdas[i] = 1 / float64(n)
+	}
+
+	return lambdas
+}
+
+// FitInterpolation will fit the lambdas of the chain interpolation to the
+// held-out text on input using expectation-maximisation, so they maximise its
+// likelihood, and returns them. The text is processed like ProcessText does,
+// but the chain is only modified to update the lambdas. It should not be part
+// of the text the chain was trained on, otherwise the longest orders get most
+// of the weight. It returns an error if the chain was not created with an
+// Interpolation or if the text has no ngram the chain knows at every order.
+//
+// Only the ngrams whose every suffix is a known prefix are used, like the full
+// ngrams of known prefixes: the interpolation leaves the unknown suffixes out
+// of the mixture, so the other ngrams, like the first ones of a text, can't
+// tell how much weight the longest orders deserve.
+func (c *NGramChain) FitInterpolation(heldOut io.Reader) ([]float64, error) {
+	var b, ok = c.chain.backoff.(*interpolation)
+	if !ok {
+		return nil, errors.New("error fitting interpolation: chain is not interpolated")
+	}
+
+	var scanner = c.newScanner(heldOut)
+
+	// the unknown tokens get temporary IDs, like when scoring
+	var s = &scorer{chain: c, unknown: make(map[string]uint32)}
+	var n = int(c.chain.n)
+	var ngrams [][]float64
+
+	var window = c.chain.newWindow()
+	window.intern = s.intern
+	window.process = func(ngram []uint32) error {
+		var key, word = packKey(ngram[:len(ngram)-1]), ngram[len(ngram)-1]
+
+		c.chain.lock.RLock()
+		var probabilities, exists = orderProbabilities(c.chain.candidates, c.chain.minCount, key, word, n)
+		c.chain.lock.RUnlock()
+
+		// ngrams missing an order, or that no order can predict, don't tell
+		// anything about the lambdas
+		if !slices.Contains(exists, false) && mixture(uniformLambdas(n), probabilities, exists) > 0 {
+			ngrams = append(ngrams, probabilities)
+		}
+
+		return nil
+	}
+
+	for scanner.Scan() {
+		if err := c.push(window, scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := window.close(); err != nil {
+		return nil, err
+	}
+
+	if len(ngrams) == 0 {
+		return nil, errors.New("error fitting interpolation: held-out text has no known ngrams")
+	}
+
+	var lambdas = fitLambdas(ngrams, n)
+
+	c.chain.lock.Lock()
+	b.lambdas = lambdas
+	c.chain.lock.Unlock()
+
+	return slices.Clone(lambdas), nil
+}
+
+// fitLambdas runs expectation-maximisation over the probabilities of every
+// order of the held-out ngrams, starting from the same weight for every order.
+// Every iteration computes how much each order contributes to the probability
+// of every ngram, and uses its share of all the contributions as the new
+// lambda of the order.
+func fitLambdas(ngrams [][]float64, n int) []float64 {
+	var lambdas = uniformLambdas(n)
+
+	for i := 0; i < emIterations; i++ {
+		var contributions = make([]float64, n)
+		for _, probabilities := range ngrams {
+			var total = 0.0
+			for k, lambda := range lambdas {
+				total += lambda * probabilities[k]
+			}
+
+			if total == 0 {
+				continue
+			}
+
+			for k, lambda := range lambdas {
+				contributions[k] += lambda * probabilities[k] / total
+			}
+		}
+
+		var sum = 0.0
+		for _, contribution := range contributions {
+			sum += contribution
+		}
+
+		if sum == 0 {
+			break
+		}
+
+		var change = 0.0
+		for k := range lambdas {
+			var lambda = contributions[k] / sum
+			change = math.Max(change, math.Abs(lambda-lambdas[k]))
+			lambdas[k] = lambda
+		}
+
+		if change < emTolerance {
+			break
+		}
+	}
+
+	return lambdas
+}
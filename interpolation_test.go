@@ -0,0 +1,245 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestInterpolation(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		prefix    string
+		candidate string
+
+		wantProbability float32
+	}{
+		{
+			name:            "ok - known prefix",
+			prefix:          "a",
+			candidate:       "b",
+			wantProbability: 0.75*0.5 + 0.25*0.25,
+		},
+		{
+			name:            "ok - unseen candidate",
+			prefix:          "a",
+			candidate:       "a",
+			wantProbability: 0.25 * 0.5,
+		},
+		{
+			name:            "ok - unknown prefix",
+			prefix:          "z",
+			candidate:       "b",
+			wantProbability: 0.25,
+		},
+		{
+			name:            "ok - unknown candidate",
+			prefix:          "a",
+			candidate:       "z",
+			wantProbability: 0,
+		},
+	}
+
+	var chain, _ = NewNGramChain(2, WithBackoff(Interpolation(0.25, 0.75)))
+	chain.ProcessText(strings.NewReader("a b a c"))
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var probability, err = chain.CandidateProbability(tt.prefix, tt.candidate)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !almostEqual(float64(probability), float64(tt.wantProbability)) {
+				t.Errorf("got %v, want %v", probability, tt.wantProbability)
+			}
+		})
+	}
+}
+
+func TestInterpolation_invalid(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name    string
+		lambdas []float64
+
+		wantErr error
+	}{
+		{
+			name:    "wrong number of lambdas",
+			lambdas: []float64{0.5, 0.5},
+			wantErr: errors.New("error initialising NGramChain: interpolation needs 3 lambdas, got 2"),
+		},
+		{
+			name:    "negative lambda",
+			lambdas: []float64{-0.5, 0.5, 1},
+			wantErr: errors.New("error initialising NGramChain: interpolation lambdas can't be negative"),
+		},
+		{
+			name:    "lambdas not adding up to 1",
+			lambdas: []float64{0.5, 0.5, 0.5},
+			wantErr: errors.New("error initialising NGramChain: interpolation lambdas must add up to 1, got 1.5"),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var _, err = NewNGramChain(3, WithBackoff(Interpolation(tt.lambdas...)))
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNGramChain_FitInterpolation(t *testing.T) {
+	t.Parallel()
+
+	var backoff = Interpolation()
+	var chain, _ = NewNGramChain(3, WithBackoff(backoff))
+	chain.ProcessText(strings.NewReader(smoothingText))
+
+	var heldOut = "I am groot. It's a wonderful trap. I am the night and the one who knocks."
+	var before, _ = chain.ScoreText(strings.NewReader(heldOut))
+
+	var lambdas, err = chain.FitInterpolation(strings.NewReader(heldOut))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(lambdas) != 3 {
+		t.Fatalf("got %v, want %v lambdas", lambdas, 3)
+	}
+
+	var sum = 0.0
+	for _, lambda := range lambdas {
+		sum += lambda
+	}
+	if !almostEqual(sum, 1) {
+		t.Errorf("got %v, want %v", sum, 1)
+	}
+
+	// fitting maximises the likelihood of the held-out text
+	var after, _ = chain.ScoreText(strings.NewReader(heldOut))
+	if after.Perplexity > before.Perplexity {
+		t.Errorf("got %v, want at most %v", after.Perplexity, before.Perplexity)
+	}
+
+	// the interpolation given on input is not modified
+	var other, _ = NewNGramChain(3, WithBackoff(backoff))
+	other.ProcessText(strings.NewReader(smoothingText))
+	var otherScore, _ = other.ScoreText(strings.NewReader(heldOut))
+	if !almostEqual(otherScore.Perplexity, before.Perplexity) {
+		t.Errorf("got %v, want %v", otherScore.Perplexity, before.Perplexity)
+	}
+}
+
+func TestNGramChain_FitInterpolation_partialNgrams(t *testing.T) {
+	t.Parallel()
+
+	// the trigrams predict the held-out text better than the bigrams, and the
+	// partial ngrams starting it must not drag the weight to the lower orders
+	var chain, _ = NewNGramChain(3, WithBackoff(Interpolation()))
+	chain.ProcessText(strings.NewReader("a b c x b d a b c x b d"))
+
+	var lambdas, err = chain.FitInterpolation(strings.NewReader("a b c x b d a b c"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if lambdas[2] < 0.99 {
+		t.Errorf("got %v, want a trigram lambda of at least %v", lambdas, 0.99)
+	}
+}
+
+func TestNGramChain_FitInterpolation_errors(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name    string
+		opts    []Option
+		heldOut string
+
+		wantErr error
+	}{
+		{
+			name:    "error - chain not interpolated",
+			opts:    []Option{WithBackoff(StupidBackoff(0.4))},
+			heldOut: "I am batman.",
+			wantErr: errors.New("error fitting interpolation: chain is not interpolated"),
+		},
+		{
+			name:    "error - no known ngrams",
+			opts:    []Option{WithBackoff(Interpolation())},
+			heldOut: "You are joker.",
+			wantErr: errors.New("error fitting interpolation: held-out text has no known ngrams"),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(2, tt.opts...)
+			chain.ProcessText(strings.NewReader("I am batman."))
+
+			var _, err = chain.FitInterpolation(strings.NewReader(tt.heldOut))
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fitLambdas(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		ngrams [][]float64
+
+		wantLambdas []float64
+	}{
+		{
+			name:        "ok - only the unigrams predict",
+			ngrams:      [][]float64{{0.5, 0}},
+			wantLambdas: []float64{1, 0},
+		},
+		{
+			name:        "ok - only the bigrams predict",
+			ngrams:      [][]float64{{0, 1}, {0, 0.5}},
+			wantLambdas: []float64{0, 1},
+		},
+		{
+			name:        "ok - both orders predict",
+			ngrams:      [][]float64{{0.5, 0}, {0, 0.5}},
+			wantLambdas: []float64{0.5, 0.5},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var lambdas = fitLambdas(tt.ngrams, 2)
+			for k := range lambdas {
+				if math.Abs(lambdas[k]-tt.wantLambdas[k]) > 1e-6 {
+					t.Errorf("got %v, want %v", lambdas, tt.wantLambdas)
+					break
+				}
+			}
+		})
+	}
+}
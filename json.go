@@ -0,0 +1,178 @@
+package markov
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// chainDocument is the JSON representation of an NGramChain
+type chainDocument struct {
+	N uint `json:"n"`
+	// Bounded is set when the chain has sequence boundaries
+	Bounded bool       `json:"bounded,omitempty"`
+	Seeds   [][]string `json:"seeds"`
+	// Occurrences is the total number of ngrams processed by the chain. It's
+	// informational only and ignored when unmarshalling, so hand edited
+	// documents don't need to keep it up to date.
+	Occurrences int                  `json:"occurrences"`
+	Transitions []transitionDocument `json:"transitions"`
+}
+
+// transitionDocument is the JSON representation of an n-1gram prefix and the
+// candidates that followed it
+type transitionDocument struct {
+	Prefix     []string            `json:"prefix"`
+	Candidates []candidateDocument `json:"candidates"`
+}
+
+// candidateDocument is the JSON representation of a wordFrequency
+type candidateDocument struct {
+	Word      string `json:"word"`
+	Frequency int    `json:"frequency"`
+}
+
+// MarshalJSON implements json.Marshaler. The chain is exported as a human
+// readable document with its metadata (n, seeds and total occurrences) and
+// the list of prefixes with their candidates and frequencies, sorted by prefix
+// so equivalent chains produce the same document.
+func (c *NGramChain) MarshalJSON() ([]byte, error) {
+	c.chain.lock.RLock()
+	defer c.chain.lock.RUnlock()
+
+	var doc = chainDocument{
+		N:           c.chain.n,
+		Bounded:     c.chain.bounded,
+		Seeds:       make([][]string, 0, len(c.chain.seeds)),
+		Transitions: make([]transitionDocument, 0, c.chain.store.len()),
+	}
+
+	for _, seed := range c.chain.seeds {
+		doc.Seeds = append(doc.Seeds, c.tokens(seed))
+	}
+
+	var prefixes = c.sortedKeys()
+	if err := storeErr(c.chain.store); err != nil {
+		return nil, fmt.Errorf("error marshalling NGramChain: %w", err)
+	}
+
+	for _, prefix := range prefixes {
+		var candidates, err = c.chain.stored(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling NGramChain: %w", err)
+		}
+
+		var transition = transitionDocument{
+			Prefix:     c.tokens(prefix),
+			Candidates: make([]candidateDocument, 0, len(candidates.words)),
+		}
+
+		for _, wf := range candidates.words {
+			transition.Candidates = append(transition.Candidates, candidateDocument{
+				Word:      c.chain.symbols.value(wf.word),
+				Frequency: wf.frequency,
+			})
+		}
+
+		if !c.chain.isLower(prefix) {
+			doc.Occurrences += candidates.occurrences
+		}
+		doc.Transitions = append(doc.Transitions, transition)
+	}
+
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, replacing the content of the
+// chain with the document produced by MarshalJSON. It can be used on a zero
+// value NGramChain, in which case n and the sequence boundaries are taken from
+// the document. Otherwise the document n and boundaries must match the chain
+// ones, and the chain must have backoff if the document has lower order ngrams. The receiver is only modified if the whole
+// document is valid, and it must not have a store backed by a file.
+func (c *NGramChain) UnmarshalJSON(data []byte) error {
+	var doc chainDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("error unmarshalling NGramChain: %w: %v", ErrInvalidFormat, err)
+	}
+
+	if doc.N <= 1 {
+		return fmt.Errorf("error unmarshalling NGramChain: %w: n must be at least 2, got %d", ErrInvalidFormat, doc.N)
+	}
+
+	if c.chain != nil && doc.N != c.chain.n {
+		return fmt.Errorf("error unmarshalling NGramChain: %w: chain has n %d, document has n %d", ErrOrderMismatch, c.chain.n, doc.N)
+	}
+
+	if c.chain != nil && doc.Bounded != c.chain.bounded {
+		return fmt.Errorf("error unmarshalling NGramChain: %w: chain has boundaries %t, document has boundaries %t",
+			ErrBoundariesMismatch, c.chain.bounded, doc.Bounded)
+	}
+
+	var cfg = &config{bounded: doc.Bounded}
+	if c.chain != nil {
+		cfg.backoff = c.chain.backoff
+		if cfg.store = c.chain.store.empty(); cfg.store == nil {
+			return fmt.Errorf("error unmarshalling NGramChain: %w", errStoreNotReplaceable)
+		}
+	}
+
+	var chain = newTextChain(doc.N, cfg)
+	for _, transition := range doc.Transitions {
+		if !chain.validPrefix(transition.Prefix) {
+			return fmt.Errorf("error unmarshalling NGramChain: %w: prefix %q has %d tokens, expected %d",
+				ErrInvalidFormat, transition.Prefix, len(transition.Prefix), doc.N-1)
+		}
+
+		var prefix = chain.internKey(transition.Prefix)
+		if _, exists := chain.storeFor(prefix).get(prefix); exists {
+			return fmt.Errorf("error unmarshalling NGramChain: %w: duplicated prefix %q", ErrInvalidFormat, transition.Prefix)
+		}
+
+		if len(transition.Candidates) == 0 {
+			return fmt.Errorf("error unmarshalling NGramChain: %w: prefix %q with no candidates", ErrInvalidFormat, transition.Prefix)
+		}
+
+		var seen = make(map[string]bool, len(transition.Candidates))
+		for _, candidate := range transition.Candidates {
+			if candidate.Frequency <= 0 {
+				return fmt.Errorf("error unmarshalling NGramChain: %w: candidate %q of prefix %q must have a positive frequency",
+					ErrInvalidFormat, candidate.Word, transition.Prefix)
+			}
+
+			if seen[candidate.Word] {
+				return fmt.Errorf("error unmarshalling NGramChain: %w: duplicated candidate %q for prefix %q",
+					ErrInvalidFormat, candidate.Word, transition.Prefix)
+			}
+			seen[candidate.Word] = true
+
+			if _, err := chain.add(prefix, chain.symbols.intern(candidate.Word), candidate.Frequency); err != nil {
+				return fmt.Errorf("error unmarshalling NGramChain: %w", err)
+			}
+		}
+	}
+
+	for _, seed := range doc.Seeds {
+		var key = chain.key(seed)
+		if _, exists := chain.store.get(key); !exists {
+			return fmt.Errorf("error unmarshalling NGramChain: %w: seed %q is not a known prefix", ErrInvalidFormat, seed)
+		}
+		chain.seeds = append(chain.seeds, key)
+	}
+
+	// initialise a zero value chain the same way the constructor would
+	if c.chain == nil {
+		var opts []Option
+		if doc.Bounded {
+			opts = append(opts, WithSequenceBoundaries())
+		}
+
+		var empty, err = NewNGramChain(doc.N, opts...)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling NGramChain: %w", err)
+		}
+		*c = *empty
+	}
+
+	c.chain.replace(chain)
+
+	return nil
+}
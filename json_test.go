@@ -0,0 +1,246 @@
+package markov
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNGramChain_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name  string
+		chain func() NGramChain
+
+		wantJSON string
+	}{
+		{
+			name:     "ok",
+			chain:    getValidChain,
+			wantJSON: `{"n":3,"seeds":[["I","am"]],"occurrences":4,"transitions":[{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":4}]}]}`,
+		},
+		{
+			name: "ok - sorted prefixes",
+			chain: func() NGramChain {
+				var c = getValidChain()
+				c.processNgram([]string{"maybe", "another", "time"})
+				c.processNgram([]string{"I", "am", "groot"})
+				return c
+			},
+			wantJSON: `{"n":3,"seeds":[["I","am"]],"occurrences":6,"transitions":[` +
+				`{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":4},{"word":"groot","frequency":1}]},` +
+				`{"prefix":["maybe","another"],"candidates":[{"word":"time","frequency":1}]}]}`,
+		},
+		{
+			name: "ok - empty chain",
+			chain: func() NGramChain {
+				var c, _ = NewNGramChain(2)
+				return *c
+			},
+			wantJSON: `{"n":2,"seeds":[],"occurrences":0,"transitions":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain = tt.chain()
+
+			var data, err = json.Marshal(&chain)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(data) != tt.wantJSON {
+				t.Errorf("got %s, want %s", data, tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestNGramChain_UnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name  string
+		chain *NGramChain
+		json  string
+
+		wantChain NGramChain
+		wantErr   error
+	}{
+		{
+			name:      "ok - zero value chain",
+			chain:     &NGramChain{},
+			json:      `{"n":3,"seeds":[["I","am"]],"transitions":[{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":4}]}]}`,
+			wantChain: getValidChain(),
+			wantErr:   nil,
+		},
+		{
+			name: "ok - initialised chain",
+			chain: func() *NGramChain {
+				var c, _ = NewNGramChain(3)
+				return c
+			}(),
+			json: `{"n":3,"seeds":[],"occurrences":12,"transitions":[{"prefix":["maybe","another"],"candidates":[{"word":"time","frequency":2}]}]}`,
+			wantChain: newTestChain(3, []testEntry{
+				{prefix: []string{"maybe", "another"}, candidates: []testCandidate{{"time", 2}}},
+			}),
+			wantErr: nil,
+		},
+		{
+			name:    "error - invalid json",
+			chain:   &NGramChain{},
+			json:    `{"n":`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "error - invalid n",
+			chain:   &NGramChain{},
+			json:    `{"n":1,"seeds":[],"transitions":[]}`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - order mismatch",
+			chain: func() *NGramChain {
+				var c, _ = NewNGramChain(2)
+				return c
+			}(),
+			json:    `{"n":3,"seeds":[],"transitions":[]}`,
+			wantErr: ErrOrderMismatch,
+		},
+		{
+			name:    "error - invalid prefix length",
+			chain:   &NGramChain{},
+			json:    `{"n":3,"seeds":[],"transitions":[{"prefix":["I"],"candidates":[{"word":"batman","frequency":4}]}]}`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:  "error - duplicated prefix",
+			chain: &NGramChain{},
+			json: `{"n":3,"seeds":[],"transitions":[` +
+				`{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":4}]},` +
+				`{"prefix":["I","am"],"candidates":[{"word":"groot","frequency":1}]}]}`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "error - duplicated candidate",
+			chain:   &NGramChain{},
+			json:    `{"n":3,"seeds":[],"transitions":[{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":4},{"word":"batman","frequency":1}]}]}`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "error - prefix without candidates",
+			chain:   &NGramChain{},
+			json:    `{"n":3,"seeds":[],"transitions":[{"prefix":["I","am"],"candidates":[]}]}`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "error - invalid frequency",
+			chain:   &NGramChain{},
+			json:    `{"n":3,"seeds":[],"transitions":[{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":0}]}]}`,
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "error - unknown seed",
+			chain:   &NGramChain{},
+			json:    `{"n":3,"seeds":[["You","are"]],"transitions":[{"prefix":["I","am"],"candidates":[{"word":"batman","frequency":4}]}]}`,
+			wantErr: ErrInvalidFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var err = tt.chain.UnmarshalJSON([]byte(tt.json))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			var entries, wantEntries = storeEntries(tt.chain.chain), storeEntries(tt.wantChain.chain)
+			if !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", entries, wantEntries)
+			}
+
+			var seeds, wantSeeds = seedTokens(tt.chain.chain), seedTokens(tt.wantChain.chain)
+			if !reflect.DeepEqual(seeds, wantSeeds) {
+				t.Errorf("got %v, want %v", seeds, wantSeeds)
+			}
+
+			if tt.chain.chain.n != 3 {
+				t.Errorf("got %v, want %v", tt.chain.chain.n, 3)
+			}
+		})
+	}
+}
+
+func TestNGramChain_JSONRoundTrip_boundaries(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithSentenceBoundaries())
+	chain.ProcessText(strings.NewReader("You are robin."))
+
+	var data, err = json.Marshal(chain)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var loaded NGramChain
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var want = "You are robin."
+	if got := loaded.GenerateRandomText(10); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var unbounded, _ = NewNGramChain(3)
+	if err := json.Unmarshal(data, unbounded); !errors.Is(err, ErrBoundariesMismatch) {
+		t.Errorf("got %v, want %v", err, ErrBoundariesMismatch)
+	}
+}
+
+func TestNGramChain_JSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	var chain = getValidChain()
+	chain.processNgram([]string{"I", "am", "groot"})
+	chain.processNgram([]string{"It's", "a", "trap"})
+
+	var data, err = json.Marshal(&chain)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var loaded NGramChain
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var entries, wantEntries = sortedEntries(loaded.chain), sortedEntries(chain.chain)
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+
+	var seeds, wantSeeds = seedTokens(loaded.chain), seedTokens(chain.chain)
+	if !reflect.DeepEqual(seeds, wantSeeds) {
+		t.Errorf("got %v, want %v", seeds, wantSeeds)
+	}
+
+	// the loaded chain must be usable straight away
+	if candidate := loaded.GetCandidate("It's a"); candidate != "trap" {
+		t.Errorf("got %v, want %v", candidate, "trap")
+	}
+}
@@ -0,0 +1,80 @@
+package markov
+
+import "sync"
+
+// chainLock guards a chain. It's a read-write lock whose write side can also be
+// shared by the goroutines adding ngrams to a chain with a striped store, since
+// the store locks its stripes itself. The first goroutine sharing the lock
+// takes it and the last one releases it.
+//
+// The readers and exclusive writers of striped chains go through a turnstile
+// while they wait for the lock, so no other goroutine can start sharing it in
+// the meantime and keep them waiting forever.
+type chainLock struct {
+	sync.RWMutex
+	striped bool
+
+	turnstile sync.Mutex
+	sharing   sync.Mutex
+	sharers   int
+}
+
+// newChainLock returns the lock of a chain, which can be shared by writers if
+// the chain store is striped
+func newChainLock(striped bool) *chainLock {
+	return &chainLock{striped: striped}
+}
+
+// RLock locks the chain for reading
+func (l *chainLock) RLock() {
+	if !l.striped {
+		l.RWMutex.RLock()
+		return
+	}
+
+	l.turnstile.Lock()
+	defer l.turnstile.Unlock()
+
+	l.RWMutex.RLock()
+}
+
+// Lock locks the chain for writing, excluding any other writer
+func (l *chainLock) Lock() {
+	if !l.striped {
+		l.RWMutex.Lock()
+		return
+	}
+
+	l.turnstile.Lock()
+	defer l.turnstile.Unlock()
+
+	l.RWMutex.Lock()
+}
+
+// lockShared locks the chain for writing along with the other goroutines
+// sharing the lock. It must only be used by chains with a striped store
+func (l *chainLock) lockShared() {
+	// wait for any reader or exclusive writer waiting for the lock
+	l.turnstile.Lock()
+	l.turnstile.Unlock()
+
+	l.sharing.Lock()
+	defer l.sharing.Unlock()
+
+	if l.sharers == 0 {
+		l.RWMutex.Lock()
+	}
+	l.sharers++
+}
+
+// unlockShared releases the lock taken with lockShared, unlocking the chain if
+// no other goroutine is sharing it
+func (l *chainLock) unlockShared() {
+	l.sharing.Lock()
+	defer l.sharing.Unlock()
+
+	l.sharers--
+	if l.sharers == 0 {
+		l.RWMutex.Unlock()
+	}
+}
@@ -0,0 +1,350 @@
+package markov
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// mappedMagic identifies the files read by OpenMappedStore
+var mappedMagic = [4]byte{'M', 'K', 'V', 'M'}
+
+// mappedVersion is the current version of the mapped store layout
+const mappedVersion = 1
+
+// mappedHeaderSize is the length of the header of a mapped store: the magic
+// followed by six uint32
+const mappedHeaderSize = 4 + 6*4
+
+// mappedCandidateSize is the length of every candidate in a mapped store, its
+// ID and frequency as uint32
+const mappedCandidateSize = 8
+
+// MappedStore is a read only Store backed by a file written by SaveMapped. The
+// file is memory mapped where the platform allows it, so the ngrams are only
+// read from disk when they're used and they can be shared by several
+// processes. Processing input with a chain using it returns ErrReadOnly. The
+// lower orders of chains with backoff are not kept in the file.
+type MappedStore struct {
+	data  []byte
+	unmap func() error
+
+	// prefixSize is the number of IDs of every prefix. The prefixes are
+	// sorted by their packed key, so they can be binary searched
+	prefixSize int
+	count      int
+
+	prefixes   []byte
+	offsets    []byte
+	candidates []byte
+	symbols    []string
+}
+
+// SaveMapped will write the ngrams and the vocabulary of the chain to w in the
+// layout read by OpenMappedStore. Every number is a little endian uint32:
+//
+//	magic       "MKVM"
+//	header      version, prefix length (n-1), prefix count, candidate count,
+//	            symbol count, symbol bytes
+//	prefixes    the IDs of every prefix, sorted by their packed bytes
+//	offsets     prefix count + 1 positions, where the candidates of every
+//	            prefix start, ending with the candidate count
+//	candidates  the ID and frequency of every candidate
+//	symbols     symbol count + 1 positions, where every symbol starts in the
+//	            symbol bytes, followed by the symbol bytes
+//
+// The symbols are the ones of the chain Vocabulary, from the first one after
+// the sequence boundaries. Lower order ngrams are not written.
+func (c *NGramChain) SaveMapped(w io.Writer) error {
+	c.chain.lock.RLock()
+	defer c.chain.lock.RUnlock()
+
+	var keys = make([]string, 0, c.chain.store.len())
+	var candidateCount = 0
+	var err error
+	c.chain.store.each(func(key string, candidates *candidates) bool {
+		keys = append(keys, key)
+		candidateCount += len(candidates.words)
+		for _, wf := range candidates.words {
+			if uint64(wf.frequency) > math.MaxUint32 {
+				err = fmt.Errorf("error saving mapped NGramChain: frequency %d too large", wf.frequency)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := storeErr(c.chain.store); err != nil {
+		return fmt.Errorf("error saving mapped NGramChain: %w", err)
+	}
+	sort.Strings(keys)
+
+	var symbols = c.chain.symbols.interned()
+	var symbolBytes = 0
+	for _, symbol := range symbols {
+		symbolBytes += len(symbol)
+	}
+
+	if uint64(candidateCount) > math.MaxUint32 || uint64(symbolBytes) > math.MaxUint32 {
+		return fmt.Errorf("error saving mapped NGramChain: chain too large")
+	}
+
+	var bw = bufio.NewWriter(w)
+	var buf [4]byte
+	var write = func(v uint32) {
+		binary.LittleEndian.PutUint32(buf[:], v)
+		bw.Write(buf[:])
+	}
+
+	bw.Write(mappedMagic[:])
+	for _, v := range []int{mappedVersion, int(c.chain.n) - 1, len(keys), candidateCount, len(symbols), symbolBytes} {
+		write(uint32(v))
+	}
+
+	for _, key := range keys {
+		bw.WriteString(key)
+	}
+
+	var offset = 0
+	for _, key := range keys {
+		write(uint32(offset))
+		var candidates, err = c.chain.stored(key)
+		if err != nil {
+			return fmt.Errorf("error saving mapped NGramChain: %w", err)
+		}
+		offset += len(candidates.words)
+	}
+	write(uint32(offset))
+
+	for _, key := range keys {
+		var candidates, err = c.chain.stored(key)
+		if err != nil {
+			return fmt.Errorf("error saving mapped NGramChain: %w", err)
+		}
+
+		for _, wf := range candidates.words {
+			write(wf.word)
+			write(uint32(wf.frequency))
+		}
+	}
+
+	offset = 0
+	for _, symbol := range symbols {
+		write(uint32(offset))
+		offset += len(symbol)
+	}
+	write(uint32(offset))
+
+	for _, symbol := range symbols {
+		bw.WriteString(symbol)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error saving mapped NGramChain: %w", err)
+	}
+
+	return nil
+}
+
+// OpenMappedStore opens a file written by SaveMapped, to be used by a chain
+// with WithStore. The store must be closed once the chain is not used anymore.
+func OpenMappedStore(path string) (*MappedStore, error) {
+	var file, err = os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mapped store: %w", err)
+	}
+	defer file.Close()
+
+	var info, statErr = file.Stat()
+	if statErr != nil {
+		return nil, fmt.Errorf("error opening mapped store: %w", statErr)
+	}
+
+	var data, unmap, mapErr = mapFile(file, int(info.Size()))
+	if mapErr != nil {
+		return nil, fmt.Errorf("error opening mapped store: %w", mapErr)
+	}
+
+	var store = &MappedStore{data: data, unmap: unmap}
+	if err := store.parse(); err != nil {
+		unmap()
+		return nil, fmt.Errorf("error opening mapped store: %w", err)
+	}
+
+	return store, nil
+}
+
+// parse checks the layout of the file and slices its sections
+func (s *MappedStore) parse() error {
+	if len(s.data) < mappedHeaderSize || !bytes.Equal(s.data[:len(mappedMagic)], mappedMagic[:]) {
+		return fmt.Errorf("%w: not a mapped store", ErrInvalidFormat)
+	}
+
+	var header [6]uint64
+	for i := range header {
+		header[i] = uint64(binary.LittleEndian.Uint32(s.data[len(mappedMagic)+4*i:]))
+	}
+
+	var version, prefixSize, count, candidateCount, symbolCount, symbolBytes = header[0], header[1], header[2], header[3], header[4], header[5]
+	if version == 0 || version > mappedVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	if prefixSize == 0 || prefixSize > math.MaxUint16 {
+		return fmt.Errorf("%w: prefixes of %d symbols", ErrInvalidFormat, prefixSize)
+	}
+
+	var sizes = []uint64{
+		count * prefixSize * idSize,
+		(count + 1) * 4,
+		candidateCount * mappedCandidateSize,
+		(symbolCount + 1) * 4,
+		symbolBytes,
+	}
+
+	var total = uint64(mappedHeaderSize)
+	for _, size := range sizes {
+		total += size
+	}
+	if total != uint64(len(s.data)) {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidFormat, total, len(s.data))
+	}
+
+	var sections = make([][]byte, len(sizes))
+	var offset = uint64(mappedHeaderSize)
+	for i, size := range sizes {
+		sections[i] = s.data[offset : offset+size]
+		offset += size
+	}
+
+	s.prefixSize = int(prefixSize)
+	s.count = int(count)
+	s.prefixes, s.offsets, s.candidates = sections[0], sections[1], sections[2]
+
+	var symbolOffsets, blob = sections[3], sections[4]
+	s.symbols = make([]string, symbolCount)
+	for i := range s.symbols {
+		var start, end = binary.LittleEndian.Uint32(symbolOffsets[4*i:]), binary.LittleEndian.Uint32(symbolOffsets[4*i+4:])
+		if start > end || uint64(end) > symbolBytes {
+			return fmt.Errorf("%w: symbol %d out of bounds", ErrInvalidFormat, i)
+		}
+		s.symbols[i] = string(blob[start:end])
+	}
+
+	for i := 0; i < s.count; i++ {
+		var start, end = s.candidateRange(i)
+		if start > end || uint64(end) > candidateCount {
+			return fmt.Errorf("%w: candidates of prefix %d out of bounds", ErrInvalidFormat, i)
+		}
+	}
+
+	return nil
+}
+
+// Close releases the file. The chain using the store can't be used afterwards
+func (s *MappedStore) Close() error {
+	var unmap = s.unmap
+	s.unmap, s.data = nil, nil
+	if unmap == nil {
+		return nil
+	}
+
+	return unmap()
+}
+
+// Increment implements Store. It always returns ErrReadOnly
+func (s *MappedStore) Increment([]uint32, uint32, int) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// Candidates implements Store
+func (s *MappedStore) Candidates(prefix []uint32) ([]Candidate, bool) {
+	return storeCandidates(s, prefix)
+}
+
+// Range implements Store, walking the prefixes sorted by their packed IDs
+func (s *MappedStore) Range(f func(prefix []uint32, candidates []Candidate) bool) {
+	rangeStore(s, f)
+}
+
+// Len implements Store
+func (s *MappedStore) Len() int {
+	return s.count
+}
+
+// Symbols implements SymbolStore
+func (s *MappedStore) Symbols() []string {
+	return append([]string(nil), s.symbols...)
+}
+
+// AddSymbol implements SymbolStore. New symbols are not kept, since no ngram
+// can be added to the store
+func (s *MappedStore) AddSymbol(string) {}
+
+func (s *MappedStore) get(key string) (*candidates, bool) {
+	var keySize = idSize * s.prefixSize
+	if len(key) != keySize {
+		return nil, false
+	}
+
+	var i = sort.Search(s.count, func(i int) bool {
+		return string(s.prefixes[i*keySize:(i+1)*keySize]) >= key
+	})
+	if i == s.count || string(s.prefixes[i*keySize:(i+1)*keySize]) != key {
+		return nil, false
+	}
+
+	return s.prefixCandidates(i), true
+}
+
+func (s *MappedStore) increment(string, uint32, int) (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (s *MappedStore) len() int {
+	return s.count
+}
+
+func (s *MappedStore) entry(i int) (string, *candidates) {
+	var keySize = idSize * s.prefixSize
+	return string(s.prefixes[i*keySize : (i+1)*keySize]), s.prefixCandidates(i)
+}
+
+func (s *MappedStore) each(f func(key string, candidates *candidates) bool) {
+	for i := 0; i < s.count; i++ {
+		if !f(s.entry(i)) {
+			return
+		}
+	}
+}
+
+func (s *MappedStore) empty() ngramStore {
+	return nil
+}
+
+// candidateRange returns the positions of the first and past the last
+// candidates of the i-th prefix
+func (s *MappedStore) candidateRange(i int) (uint32, uint32) {
+	return binary.LittleEndian.Uint32(s.offsets[4*i:]), binary.LittleEndian.Uint32(s.offsets[4*i+4:])
+}
+
+// prefixCandidates decodes the candidates of the i-th prefix
+func (s *MappedStore) prefixCandidates(i int) *candidates {
+	var start, end = s.candidateRange(i)
+
+	var candidates = &candidates{}
+	for j := start; j < end; j++ {
+		var raw = s.candidates[j*mappedCandidateSize:]
+		candidates.addCandidate(binary.LittleEndian.Uint32(raw), int(binary.LittleEndian.Uint32(raw[4:])))
+	}
+
+	return candidates
+}
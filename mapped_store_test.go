@@ -0,0 +1,112 @@
+package markov
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMappedStore(t *testing.T) {
+	t.Parallel()
+
+	var path = filepath.Join(t.TempDir(), "chain.mkvm")
+
+	var want, _ = NewNGramChain(3, WithSentenceBoundaries())
+	want.ProcessText(strings.NewReader(smoothingText))
+
+	var file, _ = os.Create(path)
+	if err := want.SaveMapped(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file.Close()
+
+	var store, err = OpenMappedStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	var chain, chainErr = NewNGramChain(3, WithSentenceBoundaries(), WithStore(store))
+	if chainErr != nil {
+		t.Fatalf("unexpected error: %v", chainErr)
+	}
+
+	if entries := sortedEntries(chain.chain); !reflect.DeepEqual(entries, sortedEntries(want.chain)) {
+		t.Errorf("got %v, want %v", entries, sortedEntries(want.chain))
+	}
+
+	if symbols := chain.Vocab().Symbols(); !reflect.DeepEqual(symbols, want.Vocab().Symbols()) {
+		t.Errorf("got %v, want %v", symbols, want.Vocab().Symbols())
+	}
+
+	for _, prefix := range []string{"I am", "a wonderful", "you are"} {
+		var got, gotErr = chain.CandidateProbability(prefix, "batman.")
+		var expected, wantErr = want.CandidateProbability(prefix, "batman.")
+		if got != expected || (gotErr == nil) != (wantErr == nil) {
+			t.Errorf("prefix %q: got %v %v, want %v %v", prefix, got, gotErr, expected, wantErr)
+		}
+	}
+
+	if text := chain.GenerateRandomText(10); text == "" {
+		t.Errorf("got %q, want a generated text", text)
+	}
+
+	if err := chain.ProcessText(strings.NewReader("I am the law.")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestOpenMappedStore_errors(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		data func(valid []byte) []byte
+
+		wantErr error
+	}{
+		{
+			name:    "error - unknown magic",
+			data:    func(valid []byte) []byte { return append([]byte("MKVC"), valid[4:]...) },
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "error - truncated",
+			data:    func(valid []byte) []byte { return valid[:len(valid)-1] },
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - unsupported version",
+			data: func(valid []byte) []byte {
+				var data = append([]byte(nil), valid...)
+				data[4] = mappedVersion + 1
+				return data
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
+	}
+
+	var chain, _ = NewNGramChain(2)
+	chain.ProcessText(strings.NewReader("I am batman."))
+
+	var buf strings.Builder
+	chain.SaveMapped(&buf)
+	var valid = []byte(buf.String())
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var path = filepath.Join(t.TempDir(), "chain.mkvm")
+			os.WriteFile(path, tt.data(valid), 0o644)
+
+			if _, err := OpenMappedStore(path); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
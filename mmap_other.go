@@ -0,0 +1,19 @@
+//go:build !unix
+
+package markov
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of the file, since memory mapping is not
+// supported on this platform
+func mapFile(file *os.File, size int) ([]byte, func() error, error) {
+	var data = make([]byte, size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return nil }, nil
+}
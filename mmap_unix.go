@@ -0,0 +1,23 @@
+//go:build unix
+
+package markov
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of the file in memory, read only. The
+// mapping stays valid once the file is closed, until unmap is called
+func mapFile(file *os.File, size int) ([]byte, func() error, error) {
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	var data, err = syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -1,59 +1,116 @@
 package markov
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"iter"
+	"slices"
+	"sort"
 	"strings"
-	"sync"
 )
 
-// NGramChain is an in memory map store that processes ngrams and uses n-1grams as
-// keys and a list of candidates as values. It's safe for concurrent use
+// NGramChain is a Chain of string tokens that processes text. It splits the
+// text on input in tokens with its Tokenizer and joins the generated tokens
+// back with its Detokenizer. It's safe for concurrent use
 type NGramChain struct {
-	store map[string]*candidates
-	n     uint
+	chain *Chain[string]
 
-	seeds    []string
-	randFunc func(n int) int
-	lock     *sync.RWMutex
+	tokenizer   Tokenizer
+	detokenizer Detokenizer
+
+	// tokens longer than maxTokenSize bytes are handled by the oversize
+	// policy
+	maxTokenSize int
+	oversize     OversizePolicy
+
+	// when sentences is set, every sentence is a sequence of the bounded chain
+	sentences   bool
+	caseFolding bool
 }
 
 // ProcessText will parse the input and split it to process the ngrams as
 // configured by the chain constructor
 func (c *NGramChain) ProcessText(text io.Reader) error {
-	var scanner = bufio.NewScanner(text)
-	scanner.Split(bufio.ScanWords)
+	var _, err = c.processTokens(context.Background(), text, c.chain.newWindow())
+	return err
+}
 
-	var ngramCount uint
-	var ngram = make([]string, c.n)
+// Processed reports how much of a text was added to a chain
+type Processed struct {
+	// Tokens is the number of tokens read from the text
+	Tokens int
+	// Ngrams is the number of ngrams added to the chain, including the lower
+	// order ones at the beginning of every sequence of chains with backoff
+	Ngrams int
+}
 
-	// process the first ngram
-	for scanner.Scan() {
-		ngram[ngramCount] = scanner.Text()
-		ngramCount++
+// ProcessTextContext will process the input like ProcessText, stopping if the
+// context is done. It returns the context error in that case, along with how
+// much of the input was added to the chain: the ngrams of the tokens read
+// until then are kept, but the last sequence of a bounded chain isn't ended.
+func (c *NGramChain) ProcessTextContext(ctx context.Context, text io.Reader) (Processed, error) {
+	var window = c.chain.newWindow()
+	var tokens, err = c.processTokens(ctx, text, window)
 
-		if ngramCount == c.n {
-			if err := c.processNgram(ngram); err != nil {
-				return err
-			}
+	return Processed{Tokens: tokens, Ngrams: window.added}, err
+}
+
+// cancelCheckInterval is the number of tokens processed between checks of the
+// context, since checking it on every token would slow down processing
+const cancelCheckInterval = 1024
+
+// processTokens splits the text in tokens and pushes them to the window,
+// closing it once the text is over. If the context is done or the text can't
+// be read first, the ngrams pushed so far are added to the chain and the error
+// is returned. It returns the number of tokens pushed.
+func (c *NGramChain) processTokens(ctx context.Context, text io.Reader, window *window[string]) (int, error) {
+	var scanner = c.newScanner(text)
+
+	var tokens = 0
+	for scanner.Scan() {
+		if tokens%cancelCheckInterval == 0 && ctx.Err() != nil {
 			break
 		}
-	}
 
-	for scanner.Scan() {
-		var nextNgram = make([]string, len(ngram))
-		copy(nextNgram, ngram[1:])
+		if err := c.push(window, scanner.Text()); err != nil {
+			return tokens, err
+		}
+		tokens++
+	}
 
-		nextNgram[len(nextNgram)-1] = scanner.Text()
+	var err = ctx.Err()
+	if err == nil {
+		err = scanner.Err()
+	}
 
-		if err := c.processNgram(nextNgram); err != nil {
-			return err
+	if err != nil {
+		// add the ngrams batched so far, leaving the sequence open
+		if flushErr := window.flush(); flushErr != nil {
+			return tokens, flushErr
 		}
 
-		ngram = nextNgram
+		return tokens, err
+	}
+
+	return tokens, window.close()
+}
+
+// push adds the token to the window, ending the current sequence of bounded
+// chains on EndToken or, with sentence boundaries, after a sentence end
+func (c *NGramChain) push(window *window[string], token string) error {
+	if c.chain.bounded && token == EndToken {
+		return window.end()
+	}
+
+	token = c.fold(token)
+	if err := window.push(token); err != nil {
+		return err
+	}
+
+	if c.sentences && isSentenceEnd(token) {
+		return window.end()
 	}
 
 	return nil
@@ -63,159 +120,231 @@ func (c *NGramChain) ProcessText(text io.Reader) error {
 // keeping random selection of candidates weighted by frequency. It will
 // generate a maximum of maxWords, less if the chain ends earlier.
 func (c *NGramChain) GenerateRandomText(maxWords uint) string {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+	var tokens = c.chain.Generate(maxWords)
 
 	// if the map is empty, no text to generate
-	if len(c.store) == 0 {
+	if len(tokens) == 0 {
 		return ""
 	}
 
-	// start with a random seed
-	var ngram = c.getRandomNGram()
+	var text = c.detokenizer.Join(tokens)
 
-	var strBuilder strings.Builder
-	strBuilder.WriteString(ngram)
+	// Add a dot at the end (if not present already)
+	if !strings.HasSuffix(text, ".") {
+		text += "."
+	}
 
-	for i := uint(0); i < maxWords; i++ {
-		var candidates, exists = c.store[ngram]
-		if !exists {
-			// if the ngram doesn't exist, end the text generation
-			break
-		}
+	return text
+}
+
+// GenerateFrom will generate a random text continuing the prompt, like an
+// autocomplete, and return up to maxWords tokens of it without the prompt. The
+// prompt is split using the chain tokenizer and the text continues from its
+// last n-1 tokens. On bounded chains, the last sequence of the prompt is
+// padded with start boundaries, so the text can continue short prompts and
+// starts a new sequence after a complete one.
+//
+// If the chain doesn't know the last n-1 tokens, the text continues from the
+// longest suffix of the prompt it knows: chains with backoff use their lower
+// orders, the others a prefix ending with that suffix. If it doesn't know the
+// last token of the prompt, there's nothing to continue and an empty string is
+// returned.
+func (c *NGramChain) GenerateFrom(prompt string, maxWords uint) string {
+	var key = c.promptKey(prompt)
+
+	c.chain.lock.RLock()
+	var resumed, exists = c.chain.resume(key)
+	var ids []uint32
+	if exists {
+		ids, _ = c.chain.generate(resumed, maxWords)
+	}
+	c.chain.lock.RUnlock()
 
-		var candidate = candidates.selectCandidate(c.randFunc)
+	if len(ids) == 0 {
+		return ""
+	}
 
-		// add the candidate to the output Builder
-		strBuilder.WriteByte(' ')
-		strBuilder.WriteString(candidate)
+	return c.detokenizer.Join(c.chain.symbols.symbols(ids))
+}
 
-		// generate new ngram with the selected candidate
-		var ngramSplit = strings.Split(ngram, " ")
-		var newNgram = make([]string, len(ngramSplit))
-		copy(newNgram, ngramSplit[1:])
+// promptKey returns the store key for the last n-1 tokens of the prompt, or
+// less if the prompt is shorter. Unknown tokens are packed as noID. For
+// bounded chains, the last sequence of the prompt is padded with start
+// boundaries.
+func (c *NGramChain) promptKey(prompt string) string {
+	var tokens = tokenize(c.tokenizer, prompt)
 
-		newNgram[len(newNgram)-1] = candidate
-		ngram = strings.Join(newNgram, " ")
+	var start = 0
+	for i := range tokens {
+		tokens[i] = c.fold(tokens[i])
+
+		if c.chain.bounded && (tokens[i] == EndToken || (c.sentences && isSentenceEnd(tokens[i]))) {
+			start = i + 1
+		}
 	}
 
-	// Add a dot at the end (if not present already)
-	if !strings.HasSuffix(strBuilder.String(), ".") {
-		strBuilder.WriteByte('.')
+	var ids = c.chain.symbols.lookup(tokens[start:])
+	if c.chain.bounded {
+		ids = append(unpackKey(c.chain.startKey()), ids...)
 	}
 
-	return strBuilder.String()
+	return packKey(ids[max(0, len(ids)-int(c.chain.n)+1):])
+}
+
+// GenerateOptions configures the text generated by Generate
+type GenerateOptions struct {
+	// MaxTokens is the maximum number of tokens selected, not counting the
+	// seed the text of unbounded chains starts with, like the maxWords of
+	// GenerateRandomText. With 0, the text goes on until the chain ends it,
+	// which might never happen without boundaries.
+	MaxTokens uint
+}
+
+// Generate returns an iterator over the tokens of a random text, generated
+// like GenerateRandomText but one token at a time, so they can be streamed as
+// they're selected. The chain is only locked while selecting every token, so
+// it can be written while the text is consumed. The iteration stops once the
+// text ends, the loop breaks or the context is done, in which case the caller
+// can check ctx.Err().
+func (c *NGramChain) Generate(ctx context.Context, opts GenerateOptions) iter.Seq[string] {
+	return func(yield func(token string) bool) {
+		for id := range c.chain.stream(ctx, opts.MaxTokens) {
+			if !yield(c.chain.symbols.value(id)) {
+				return
+			}
+		}
+	}
 }
 
 // GetCandidate will select and return a candidate for the given n-1gram prefix. It will return an empty
-// string if the prefix doesn't exist
+// string if the prefix doesn't exist, unless the chain has backoff. The prefix is split using the chain
+// tokenizer
 func (c *NGramChain) GetCandidate(prefix string) string {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+	var key = c.prefixKey(prefix)
+
+	c.chain.lock.RLock()
+	defer c.chain.lock.RUnlock()
 
-	var candidates, exists = c.store[prefix]
+	var id, exists = c.chain.next(key)
 	if !exists {
 		return ""
 	}
 
-	return candidates.selectCandidate(c.randFunc)
+	return c.chain.symbols.value(id)
 }
 
 // CandidateProbability will check what the probability of a given candidate is
 // for a given n-1gram prefix. If the candidate does not exist, 0 is returned. If
-// the prefix does not exist, an error is returned
+// the prefix does not exist, an error is returned, unless the chain is smoothed
+// or has backoff. The prefix is split using the chain tokenizer
 func (c *NGramChain) CandidateProbability(prefix string, candidate string) (float32, error) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-
-	var candidates, exists = c.store[prefix]
-	if !exists {
-		return 0.0, errors.New("prefix does not exist")
-	}
+	var key = c.prefixKey(prefix)
 
-	var wordFreq = candidates.getCandidate(candidate)
-	if wordFreq == nil {
-		return 0.0, nil
-	}
+	c.chain.lock.RLock()
+	defer c.chain.lock.RUnlock()
 
-	return float32(wordFreq.frequency) / float32(candidates.occurrences), nil
+	var probability, err = c.chain.probability(key, c.fold(candidate))
+	return float32(probability), err
 }
 
-// processNgram will extract the ngram and candidate from the input and
-// either add it to the map if it doesn't exist or increase frequency/add the
-// new candidate
+// processNgram will intern the tokens on input and process them as an ngram
+// of the chain
 func (c *NGramChain) processNgram(input []string) error {
-	// in order to process the ngram we need n on input
-	if len(input) != int(c.n) {
-		return fmt.Errorf("error processing ngram, expected input length %d, got %d", c.n, len(input))
+	var ngram = make([]uint32, len(input))
+	for i, token := range input {
+		ngram[i] = c.chain.symbols.intern(token)
 	}
 
-	// construct the key for the ngram map by concatenating the n-1 first words on
-	// input. Use space as separator to preserve the original message and
-	// differentiate between cases like "a bc" and "ab c"
-	var ngram = strings.Join(input[:len(input)-1], " ")
-	var candidate = input[len(input)-1]
-
-	// lock the map to prevent racy reads while the writes are ongoing
-	c.lock.Lock()
-	defer c.lock.Unlock()
+	return c.chain.processNgram(ngram)
+}
 
-	// if the ngram already exists add the candidate to its list
-	if candidates, exists := c.store[ngram]; exists {
-		candidates.processCandidate(candidate)
-		return nil
+// sortedKeys returns the store keys, including the lower order ones of chains
+// with backoff, sorted by their tokens in lexicographical order. The caller
+// must hold the read lock.
+func (c *NGramChain) sortedKeys() []string {
+	var keys = make([]string, 0, c.chain.store.len()+c.chain.lower.len())
+	for _, store := range []ngramStore{c.chain.store, c.chain.lower} {
+		store.each(func(key string, _ *candidates) bool {
+			keys = append(keys, key)
+			return true
+		})
 	}
 
-	// otherwise add it to the map
-	var candidates = &candidates{}
-	candidates.processCandidate(candidate)
-
-	c.store[ngram] = candidates
-
-	// if the ngram starts with upper case, add it to the seed ngram list
-	if ngram[0] >= 'A' && ngram[0] <= 'Z' {
-		c.seeds = append(c.seeds, ngram)
+	var tokens = make(map[string][]string, len(keys))
+	for _, key := range keys {
+		tokens[key] = c.tokens(key)
 	}
 
-	return nil
+	sort.Slice(keys, func(i, j int) bool {
+		return slices.Compare(tokens[keys[i]], tokens[keys[j]]) < 0
+	})
+
+	return keys
 }
 
-// getRandomNGram returns a random ngram from the internal map. It will use
-// the seeds if available
-func (c *NGramChain) getRandomNGram() string {
-	var ngram string
+// tokens returns the tokens of the given store key
+func (c *NGramChain) tokens(key string) []string {
+	return c.chain.symbols.symbols(unpackKey(key))
+}
 
-	// if there are seeds use them
-	if len(c.seeds) > 0 {
-		return c.seeds[c.randFunc(len(c.seeds))]
+// prefixKey splits the prefix using the chain tokenizer and returns its store key
+func (c *NGramChain) prefixKey(prefix string) string {
+	var tokens = tokenize(c.tokenizer, prefix)
+	for i := range tokens {
+		tokens[i] = c.fold(tokens[i])
 	}
 
-	// otherwise pick a random ngram from the map
-	var pos = c.randFunc(len(c.store))
-	for k := range c.store {
-		if pos == 0 {
-			ngram = k
-			break
-		}
-		pos--
+	return c.chain.key(tokens)
+}
+
+// fold returns the token in lower case if the chain is case insensitive
+func (c *NGramChain) fold(token string) string {
+	if !c.caseFolding {
+		return token
 	}
 
-	return ngram
+	return strings.ToLower(token)
 }
 
 // NewNGramChain will initialise an ngram chain. The n on input will determine
 // the length of the ngrams processed by the chain to produce the key (n-1gram)
-// and candidates
-func NewNGramChain(n uint) (*NGramChain, error) {
+// and candidates. The options on input can be used to customise the chain
+// behaviour, see the With* functions. Without options, the chain splits the
+// text in words, uses upper case prefixes as seeds and the math/rand global
+// source
+func NewNGramChain(n uint, opts ...Option) (*NGramChain, error) {
 	if n <= 1 {
 		return nil, errors.New("error initialising NGramChain: n must be at least 2")
 	}
 
+	var cfg, err = newConfig(n, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising NGramChain: %w", err)
+	}
+
+	var chain = newTextChain(n, cfg)
+	if err := chain.openStore(cfg.symbolStore); err != nil {
+		return nil, fmt.Errorf("error initialising NGramChain: %w", err)
+	}
+
 	return &NGramChain{
-		store: make(map[string]*candidates),
-		// having the randFunc as a field of the NGramChain allows for testing with deterministic output
-		randFunc: rand.Intn,
-		lock:     &sync.RWMutex{},
-		n:        n,
+		chain:        chain,
+		tokenizer:    cfg.tokenizer,
+		detokenizer:  cfg.detokenizer,
+		maxTokenSize: cfg.maxTokenSize,
+		oversize:     cfg.oversize,
+		sentences:    cfg.sentences,
+		caseFolding:  cfg.caseFolding,
 	}, nil
 }
+
+// newTextChain returns an empty chain of string tokens, where StartToken and
+// EndToken are interned as the sequence boundaries
+func newTextChain(n uint, cfg *config) *Chain[string] {
+	var chain = newChain[string](n, cfg)
+	chain.seedPolicy = cfg.seedPolicy
+	chain.symbols.alias(StartToken, startID)
+	chain.symbols.alias(EndToken, endID)
+
+	return chain
+}
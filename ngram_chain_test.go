@@ -1,13 +1,17 @@
 package markov
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/kr/pretty"
@@ -25,38 +29,18 @@ func Test_ProcessText(t *testing.T) {
 		body io.Reader
 		n    uint
 
-		wantMap map[string]*candidates
-		wantErr error
+		wantEntries []testEntry
+		wantErr     error
 	}{
 		{
 			name: "ok - process trigrams",
 			body: getValidReader(),
 			n:    3,
-			wantMap: map[string]*candidates{
-				"a b": &candidates{
-					words: []wordFrequency{
-						{word: "c", frequency: 1},
-					},
-					occurrences: 1,
-				},
-				"b c": &candidates{
-					words: []wordFrequency{
-						{word: "d", frequency: 1},
-					},
-					occurrences: 1,
-				},
-				"c d": &candidates{
-					words: []wordFrequency{
-						{word: "e", frequency: 1},
-					},
-					occurrences: 1,
-				},
-				"d e": &candidates{
-					words: []wordFrequency{
-						{word: "f", frequency: 1},
-					},
-					occurrences: 1,
-				},
+			wantEntries: []testEntry{
+				{prefix: []string{"a", "b"}, candidates: []testCandidate{{"c", 1}}},
+				{prefix: []string{"b", "c"}, candidates: []testCandidate{{"d", 1}}},
+				{prefix: []string{"c", "d"}, candidates: []testCandidate{{"e", 1}}},
+				{prefix: []string{"d", "e"}, candidates: []testCandidate{{"f", 1}}},
 			},
 			wantErr: nil,
 		},
@@ -64,25 +48,10 @@ func Test_ProcessText(t *testing.T) {
 			name: "ok - process 4-grams",
 			body: getValidReader(),
 			n:    4,
-			wantMap: map[string]*candidates{
-				"a b c": &candidates{
-					words: []wordFrequency{
-						{word: "d", frequency: 1},
-					},
-					occurrences: 1,
-				},
-				"b c d": &candidates{
-					words: []wordFrequency{
-						{word: "e", frequency: 1},
-					},
-					occurrences: 1,
-				},
-				"c d e": &candidates{
-					words: []wordFrequency{
-						{word: "f", frequency: 1},
-					},
-					occurrences: 1,
-				},
+			wantEntries: []testEntry{
+				{prefix: []string{"a", "b", "c"}, candidates: []testCandidate{{"d", 1}}},
+				{prefix: []string{"b", "c", "d"}, candidates: []testCandidate{{"e", 1}}},
+				{prefix: []string{"c", "d", "e"}, candidates: []testCandidate{{"f", 1}}},
 			},
 			wantErr: nil,
 		},
@@ -93,20 +62,120 @@ func Test_ProcessText(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			var NGramChain = NGramChain{
-				store:    map[string]*candidates{},
-				randFunc: dummyRandFunc,
-				lock:     &sync.RWMutex{},
-				n:        tt.n,
-			}
+			var NGramChain = newTestChain(tt.n, nil)
 
 			var err = NGramChain.ProcessText(tt.body)
 			if !reflect.DeepEqual(err, tt.wantErr) {
 				t.Errorf("got %v, want %v", err, tt.wantErr)
 			}
 
-			if !reflect.DeepEqual(NGramChain.store, tt.wantMap) {
-				t.Errorf("got %v, want %v", NGramChain.store, tt.wantMap)
+			if entries := storeEntries(NGramChain.chain); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("got %v, want %v", entries, tt.wantEntries)
+			}
+		})
+	}
+}
+
+// cancelingReader cancels a context once more than after bytes were read. It
+// reads 100 bytes at most on every call, so the context is canceled right
+// after reading them
+type cancelingReader struct {
+	r      io.Reader
+	after  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	var n, err = r.r.Read(p[:min(len(p), 100)])
+	r.after -= n
+	if r.after < 0 {
+		r.cancel()
+	}
+
+	return n, err
+}
+
+func TestNGramChain_ProcessTextContext(t *testing.T) {
+	t.Parallel()
+
+	var text = strings.Repeat("I am batman. ", 10000)
+
+	var tests = []struct {
+		name string
+		opts func() []Option
+		// cancelAfter is the number of bytes read before the context is
+		// canceled, or -1 to never cancel it
+		cancelAfter int
+
+		wantProcessed Processed
+		wantErr       error
+	}{
+		{
+			name:          "whole text",
+			cancelAfter:   -1,
+			wantProcessed: Processed{Tokens: 30000, Ngrams: 29998},
+		},
+		{
+			name:          "canceled before processing",
+			cancelAfter:   0,
+			wantProcessed: Processed{},
+			wantErr:       context.Canceled,
+		},
+		{
+			name:          "canceled while processing",
+			cancelAfter:   20000,
+			wantProcessed: Processed{Tokens: 5120, Ngrams: 5118},
+			wantErr:       context.Canceled,
+		},
+		{
+			name:          "canceled while processing with a sharded store",
+			opts:          func() []Option { return []Option{WithStore(NewShardedStore(0))} },
+			cancelAfter:   20000,
+			wantProcessed: Processed{Tokens: 5120, Ngrams: 5118},
+			wantErr:       context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var opts []Option
+			if tt.opts != nil {
+				opts = tt.opts()
+			}
+			var chain, _ = NewNGramChain(3, opts...)
+
+			var ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+
+			var reader io.Reader = strings.NewReader(text)
+			if tt.cancelAfter == 0 {
+				cancel()
+			} else if tt.cancelAfter > 0 {
+				reader = &cancelingReader{r: reader, after: tt.cancelAfter, cancel: cancel}
+			}
+
+			var processed, err = chain.ProcessTextContext(ctx, reader)
+			if err != tt.wantErr {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+
+			if processed != tt.wantProcessed {
+				t.Errorf("got %v, want %v", processed, tt.wantProcessed)
+			}
+
+			// the ngrams reported are the ones in the chain
+			var ngrams = 0
+			for _, entry := range storeEntries(chain.chain) {
+				for _, candidate := range entry.candidates {
+					ngrams += candidate.frequency
+				}
+			}
+
+			if ngrams != processed.Ngrams {
+				t.Errorf("got %v, want %v", ngrams, processed.Ngrams)
 			}
 		})
 	}
@@ -116,53 +185,34 @@ func TestNGramChain_GenerateRandomText(t *testing.T) {
 	t.Parallel()
 
 	var getMap = func() NGramChain {
-		return NGramChain{
-			store: map[string]*candidates{
-				"It's a": &candidates{
-					words: []wordFrequency{
-						{word: "trap", frequency: 1},
-						{word: "wonderful", frequency: 5},
-					},
-					occurrences: 6,
-				},
-				"I am": &candidates{
-					words: []wordFrequency{
-						{word: "batman", frequency: 4},
-					},
-					occurrences: 4,
-				},
-				"a wonderful": &candidates{
-					words: []wordFrequency{
-						{word: "world.", frequency: 2},
-						{word: "planet", frequency: 3},
-						{word: "day", frequency: 2},
-					},
-					occurrences: 7,
-				},
-				"wonderful planet": &candidates{
-					words: []wordFrequency{
-						{word: "we", frequency: 9},
-					},
-					occurrences: 9,
-				},
-				"planet we": &candidates{
-					words: []wordFrequency{
-						{word: "live", frequency: 3},
-					},
-					occurrences: 3,
-				},
-				"we live": &candidates{
-					words: []wordFrequency{
-						{word: "on", frequency: 5},
-						{word: "tomorrow", frequency: 1},
-					},
-					occurrences: 6,
-				},
+		var m = newTestChain(3, []testEntry{
+			{
+				prefix:     []string{"It's", "a"},
+				candidates: []testCandidate{{"trap", 1}, {"wonderful", 5}},
 			},
-			seeds:    []string{"I am", "It's a"},
-			randFunc: func(int) int { return 1 },
-			lock:     &sync.RWMutex{},
-		}
+			{
+				prefix:     []string{"I", "am"},
+				candidates: []testCandidate{{"batman", 4}},
+			},
+			{
+				prefix:     []string{"a", "wonderful"},
+				candidates: []testCandidate{{"world.", 2}, {"planet", 3}, {"day", 2}},
+			},
+			{
+				prefix:     []string{"wonderful", "planet"},
+				candidates: []testCandidate{{"we", 9}},
+			},
+			{
+				prefix:     []string{"planet", "we"},
+				candidates: []testCandidate{{"live", 3}},
+			},
+			{
+				prefix:     []string{"we", "live"},
+				candidates: []testCandidate{{"on", 5}, {"tomorrow", 1}},
+			},
+		}, []string{"I", "am"}, []string{"It's", "a"})
+		m.chain.randFunc = func(int) int { return 1 }
+		return m
 	}
 
 	var emptyMap, _ = NewNGramChain(3)
@@ -180,19 +230,9 @@ func TestNGramChain_GenerateRandomText(t *testing.T) {
 		},
 		{
 			name: "ok - no seeds",
-			NGramChain: NGramChain{
-				store: map[string]*candidates{
-					"i am": &candidates{
-						words: []wordFrequency{
-							{word: "batman", frequency: 4},
-						},
-						occurrences: 4,
-					},
-				},
-				seeds:    []string{},
-				randFunc: func(int) int { return 0 },
-				lock:     &sync.RWMutex{},
-			},
+			NGramChain: newTestChain(3, []testEntry{
+				{prefix: []string{"i", "am"}, candidates: []testCandidate{{"batman", 4}}},
+			}),
 			wantText: "i am batman.",
 		},
 		{
@@ -204,8 +244,12 @@ func TestNGramChain_GenerateRandomText(t *testing.T) {
 			name: "ok - multiple bigrams",
 			NGramChain: func() NGramChain {
 				var m = getMap()
-				m.seeds = []string{"I am", "Nope", "It's a"}
-				m.randFunc = func(int) int { return 2 }
+				m.chain.seeds = []string{
+					m.chain.internKey([]string{"I", "am"}),
+					m.chain.internKey([]string{"Nope"}),
+					m.chain.internKey([]string{"It's", "a"}),
+				}
+				m.chain.randFunc = func(int) int { return 2 }
 				return m
 			}(),
 			wantText: "It's a wonderful planet we live on.",
@@ -214,7 +258,7 @@ func TestNGramChain_GenerateRandomText(t *testing.T) {
 			name: "ok - one ngram adding .",
 			NGramChain: func() NGramChain {
 				var m = getMap()
-				m.randFunc = func(int) int { return 0 }
+				m.chain.randFunc = func(int) int { return 0 }
 				return m
 			}(),
 			wantText: "I am batman.",
@@ -235,6 +279,239 @@ func TestNGramChain_GenerateRandomText(t *testing.T) {
 	}
 }
 
+func TestNGramChain_GenerateRandomText_noLimit(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2)
+	chain.ProcessText(strings.NewReader("a b c d e f"))
+
+	// the limit is only an upper bound, the text ends with the chain
+	if text := chain.GenerateRandomText(math.MaxUint); !strings.HasSuffix(text, "e f.") {
+		t.Errorf("got %q, want a text ending with %q", text, "e f.")
+	}
+}
+
+func TestNGramChain_Generate(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		opts      []Option
+		maxTokens uint
+	}{
+		{name: "unbounded", maxTokens: 20},
+		{name: "sentence boundaries", opts: []Option{WithSentenceBoundaries()}, maxTokens: 20},
+		{name: "sentence boundaries without limit", opts: []Option{WithSentenceBoundaries()}},
+		{name: "backoff", opts: []Option{WithBackoff(StupidBackoff(0.4))}, maxTokens: 20},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var want, _ = NewNGramChain(3, append(tt.opts, WithSeed(1))...)
+			var chain, _ = NewNGramChain(3, append(tt.opts, WithSeed(1))...)
+			for _, c := range []*NGramChain{want, chain} {
+				c.ProcessText(strings.NewReader(smoothingText))
+			}
+
+			// the tokens are the ones Chain.Generate selects with the same
+			// randomness
+			for i := 0; i < 5; i++ {
+				var maxLen = tt.maxTokens
+				if maxLen == 0 {
+					maxLen = 1000
+				}
+				var wantTokens = want.chain.Generate(maxLen)
+
+				var tokens []string
+				for token := range chain.Generate(context.Background(), GenerateOptions{MaxTokens: tt.maxTokens}) {
+					tokens = append(tokens, token)
+				}
+
+				if !reflect.DeepEqual(tokens, wantTokens) {
+					t.Errorf("got %v, want %v", tokens, wantTokens)
+				}
+			}
+		})
+	}
+}
+
+func TestNGramChain_GenerateFrom(t *testing.T) {
+	t.Parallel()
+
+	var text = "The quick brown fox jumps over the lazy dog."
+
+	var tests = []struct {
+		name     string
+		opts     []Option
+		prompt   string
+		maxWords uint
+
+		wantText []string
+	}{
+		{
+			name:     "prompt of n-1 tokens",
+			prompt:   "The quick",
+			maxWords: 3,
+			wantText: []string{"brown fox jumps"},
+		},
+		{
+			name:     "last n-1 tokens of the prompt",
+			prompt:   "I saw the lazy",
+			maxWords: 5,
+			wantText: []string{"dog."},
+		},
+		{
+			name:     "unknown prompt",
+			prompt:   "a slow brown",
+			maxWords: 3,
+			wantText: []string{"fox jumps over"},
+		},
+		{
+			name:     "short prompt",
+			prompt:   "brown",
+			maxWords: 3,
+			wantText: []string{"fox jumps over"},
+		},
+		{
+			name:     "prompt ending with unknown tokens",
+			prompt:   "quick brown cat",
+			maxWords: 3,
+			wantText: []string{""},
+		},
+		{
+			name:     "unknown prompt with backoff",
+			opts:     []Option{WithBackoff(StupidBackoff(0.4))},
+			prompt:   "a slow brown",
+			maxWords: 3,
+			wantText: []string{"fox jumps over"},
+		},
+		{
+			name:     "short prompt with backoff",
+			opts:     []Option{WithBackoff(KatzBackoff())},
+			prompt:   "brown",
+			maxWords: 3,
+			wantText: []string{"fox jumps over"},
+		},
+		{
+			name:     "case folding",
+			opts:     []Option{WithCaseFolding()},
+			prompt:   "THE QUICK",
+			maxWords: 2,
+			wantText: []string{"brown fox"},
+		},
+		{
+			name:     "short prompt with sentence boundaries",
+			opts:     []Option{WithSentenceBoundaries()},
+			prompt:   "The",
+			maxWords: 3,
+			wantText: []string{"quick brown fox"},
+		},
+		{
+			name:     "last sentence of the prompt",
+			opts:     []Option{WithSentenceBoundaries()},
+			prompt:   "I am batman. The",
+			maxWords: 2,
+			wantText: []string{"quick brown"},
+		},
+		{
+			name:     "complete sentence",
+			opts:     []Option{WithSentenceBoundaries()},
+			prompt:   "I am batman.",
+			maxWords: 20,
+			wantText: []string{"The quick brown fox jumps over the lazy dog.", "I am groot."},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(3, tt.opts...)
+			chain.ProcessText(strings.NewReader(text))
+			chain.ProcessText(strings.NewReader("I am groot."))
+
+			if text := chain.GenerateFrom(tt.prompt, tt.maxWords); !slices.Contains(tt.wantText, text) {
+				t.Errorf("got %q, want one of %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestNGramChain_GenerateFrom_newKeys(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3)
+	chain.ProcessText(strings.NewReader("a b c"))
+
+	if text := chain.GenerateFrom("x b", 1); text != "c" {
+		t.Errorf("got %q, want %q", text, "c")
+	}
+
+	// the keys added afterwards can be continued too
+	chain.ProcessText(strings.NewReader("d e f"))
+	if text := chain.GenerateFrom("x e", 1); text != "f" {
+		t.Errorf("got %q, want %q", text, "f")
+	}
+}
+
+func TestNGramChain_GenerateFrom_noLimit(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2)
+	chain.ProcessText(strings.NewReader("a b c d e f"))
+
+	if text := chain.GenerateFrom("c", math.MaxUint); text != "d e f" {
+		t.Errorf("got %q, want %q", text, "d e f")
+	}
+}
+
+func TestNGramChain_Generate_stop(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2)
+	chain.ProcessText(strings.NewReader("I am I am I am"))
+
+	// the loop can stop early, even without limit
+	var tokens []string
+	for token := range chain.Generate(context.Background(), GenerateOptions{}) {
+		tokens = append(tokens, token)
+		if len(tokens) == 5 {
+			break
+		}
+	}
+
+	if want := []string{"I", "am", "I", "am", "I"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+
+	// so can the context, and the chain can be written while generating
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	tokens = nil
+	for token := range chain.Generate(ctx, GenerateOptions{}) {
+		tokens = append(tokens, token)
+		chain.ProcessText(strings.NewReader("I am"))
+		if len(tokens) == 3 {
+			cancel()
+		}
+	}
+
+	if len(tokens) != 3 {
+		t.Errorf("got %v, want %v", len(tokens), 3)
+	}
+
+	// empty chains generate nothing
+	var empty, _ = NewNGramChain(2)
+	for token := range empty.Generate(context.Background(), GenerateOptions{}) {
+		t.Errorf("got %q, want no token", token)
+	}
+}
+
 func TestNGramChain_Next(t *testing.T) {
 	t.Parallel()
 
@@ -329,99 +606,58 @@ func TestNGramChain_processNgram(t *testing.T) {
 
 	var tests = []struct {
 		name  string
-		n     uint
 		ngram []string
 
-		wantMap NGramChain
-		wantErr error
+		wantEntries []testEntry
+		wantSeeds   [][]string
+		wantErr     error
 	}{
 		{
 			name:  "ok - new ngram with seed",
-			n:     3,
 			ngram: []string{"It's", "a", "trap"},
-			wantMap: NGramChain{
-				store: map[string]*candidates{
-					"It's a": &candidates{
-						words: []wordFrequency{
-							{word: "trap", frequency: 1},
-						},
-						occurrences: 1,
-					},
-					"I am": &candidates{
-						words: []wordFrequency{
-							{word: "batman", frequency: 4},
-						},
-						occurrences: 4,
-					},
-				},
-				seeds: []string{"I am", "It's a"},
+			wantEntries: []testEntry{
+				{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 4}}},
+				{prefix: []string{"It's", "a"}, candidates: []testCandidate{{"trap", 1}}},
 			},
-			wantErr: nil,
+			wantSeeds: [][]string{{"I", "am"}, {"It's", "a"}},
+			wantErr:   nil,
 		},
 		{
 			name:  "ok - new ngram no seed",
-			n:     3,
 			ngram: []string{"maybe", "another", "time"},
-			wantMap: NGramChain{
-				store: map[string]*candidates{
-					"maybe another": &candidates{
-						words: []wordFrequency{
-							{word: "time", frequency: 1},
-						},
-						occurrences: 1,
-					},
-					"I am": &candidates{
-						words: []wordFrequency{
-							{word: "batman", frequency: 4},
-						},
-						occurrences: 4,
-					},
-				},
-				seeds: []string{"I am"},
+			wantEntries: []testEntry{
+				{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 4}}},
+				{prefix: []string{"maybe", "another"}, candidates: []testCandidate{{"time", 1}}},
 			},
-			wantErr: nil,
+			wantSeeds: [][]string{{"I", "am"}},
+			wantErr:   nil,
 		},
 		{
 			name:  "ok - existing ngram new candidate",
-			n:     3,
 			ngram: []string{"I", "am", "groot"},
-			wantMap: NGramChain{
-				store: map[string]*candidates{
-					"I am": &candidates{
-						words: []wordFrequency{
-							{word: "batman", frequency: 4},
-							{word: "groot", frequency: 1},
-						},
-						occurrences: 5,
-					},
-				},
-				seeds: []string{"I am"},
+			wantEntries: []testEntry{
+				{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 4}, {"groot", 1}}},
 			},
-			wantErr: nil,
+			wantSeeds: [][]string{{"I", "am"}},
+			wantErr:   nil,
 		},
 		{
 			name:  "ok - existing ngram existing candidate",
-			n:     3,
 			ngram: []string{"I", "am", "batman"},
-			wantMap: NGramChain{
-				store: map[string]*candidates{
-					"I am": &candidates{
-						words: []wordFrequency{
-							{word: "batman", frequency: 5},
-						},
-						occurrences: 5,
-					},
-				},
-				seeds: []string{"I am"},
+			wantEntries: []testEntry{
+				{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 5}}},
 			},
-			wantErr: nil,
+			wantSeeds: [][]string{{"I", "am"}},
+			wantErr:   nil,
 		},
 		{
-			name:    "error - invalid input",
-			n:       3,
-			ngram:   []string{"I", "am"},
-			wantMap: getValidChain(),
-			wantErr: errors.New("error processing ngram, expected input length 3, got 2"),
+			name:  "error - invalid input",
+			ngram: []string{"I", "am"},
+			wantEntries: []testEntry{
+				{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 4}}},
+			},
+			wantSeeds: [][]string{{"I", "am"}},
+			wantErr:   errors.New("error processing ngram, expected input length 3, got 2"),
 		},
 	}
 
@@ -431,19 +667,18 @@ func TestNGramChain_processNgram(t *testing.T) {
 			t.Parallel()
 
 			var NGramChain = getValidChain()
-			NGramChain.n = tt.n
 
 			var err = NGramChain.processNgram(tt.ngram)
 			if !reflect.DeepEqual(err, tt.wantErr) {
 				t.Errorf("got %v, want %v", err, tt.wantErr)
 			}
 
-			if !reflect.DeepEqual(NGramChain.store, tt.wantMap.store) {
-				t.Errorf("got %v, want %v", NGramChain.store, tt.wantMap.store)
+			if entries := storeEntries(NGramChain.chain); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("got %v, want %v", entries, tt.wantEntries)
 			}
 
-			if !reflect.DeepEqual(NGramChain.seeds, tt.wantMap.seeds) {
-				t.Errorf("got %v, want %v", NGramChain.seeds, tt.wantMap.seeds)
+			if seeds := seedTokens(NGramChain.chain); !reflect.DeepEqual(seeds, tt.wantSeeds) {
+				t.Errorf("got %v, want %v", seeds, tt.wantSeeds)
 			}
 		})
 	}
@@ -456,21 +691,21 @@ func TestNGramChain_getRandomBigram(t *testing.T) {
 		name       string
 		NGramChain NGramChain
 
-		wantBigram string
+		wantBigram []string
 	}{
 		{
 			name:       "ok - with seeds",
 			NGramChain: getValidChain(),
-			wantBigram: "I am",
+			wantBigram: []string{"I", "am"},
 		},
 		{
 			name: "ok - without seeds",
 			NGramChain: func() NGramChain {
 				var m = getValidChain()
-				m.seeds = []string{}
+				m.chain.seeds = []string{}
 				return m
 			}(),
-			wantBigram: "I am",
+			wantBigram: []string{"I", "am"},
 		},
 	}
 
@@ -479,7 +714,7 @@ func TestNGramChain_getRandomBigram(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			var ngram = tt.NGramChain.getRandomNGram()
+			var ngram = tt.NGramChain.tokens(tt.NGramChain.chain.getRandomNGram())
 			if !reflect.DeepEqual(ngram, tt.wantBigram) {
 				t.Errorf("got %v, want %v", ngram, tt.wantBigram)
 			}
@@ -494,6 +729,7 @@ func Test_NewNGramChain(t *testing.T) {
 	var tests = []struct {
 		name string
 		n    uint
+		opts []Option
 
 		wantMap *NGramChain
 		wantErr error
@@ -503,11 +739,29 @@ func Test_NewNGramChain(t *testing.T) {
 			n:       3,
 			wantErr: nil,
 		},
+		{
+			name:    "ok - with options",
+			n:       3,
+			opts:    []Option{WithTokenizer(RuneTokenizer{}), WithDetokenizer(WordTokenizer{})},
+			wantErr: nil,
+		},
 		{
 			name:    "error - invalid n",
 			n:       1,
 			wantErr: errors.New("error initialising NGramChain: n must be at least 2"),
 		},
+		{
+			name:    "error - nil tokenizer",
+			n:       3,
+			opts:    []Option{WithTokenizer(nil)},
+			wantErr: fmt.Errorf("error initialising NGramChain: %w", errors.New("tokenizer can't be nil")),
+		},
+		{
+			name:    "error - nil detokenizer",
+			n:       3,
+			opts:    []Option{WithDetokenizer(nil)},
+			wantErr: fmt.Errorf("error initialising NGramChain: %w", errors.New("detokenizer can't be nil")),
+		},
 	}
 
 	for _, tt := range tests {
@@ -515,7 +769,7 @@ func Test_NewNGramChain(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			var _, err = NewNGramChain(tt.n)
+			var _, err = NewNGramChain(tt.n, tt.opts...)
 			if !reflect.DeepEqual(err, tt.wantErr) {
 				t.Errorf("got %v, want %v", err, tt.wantErr)
 			}
@@ -526,74 +780,215 @@ func Test_NewNGramChain(t *testing.T) {
 func TestNGramChain_Concurrency(t *testing.T) {
 	t.Parallel()
 
+	var tests = []struct {
+		name string
+		opts []Option
+	}{
+		{name: "map store"},
+		{name: "sharded store", opts: []Option{WithStore(NewShardedStore(0))}},
+	}
+
 	var trigrams = [][]string{
 		{"a", "a", "b"},
 		{"a", "a", "c"},
 		{"a", "a", "d"},
 	}
 
-	var NGramChain, _ = NewNGramChain(3)
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var NGramChain, _ = NewNGramChain(3, tt.opts...)
+
+			var wg = &sync.WaitGroup{}
+
+			// spawn the writers
+			for _, trigram := range trigrams {
+				wg.Add(1)
+				go func(tr []string) {
+					for x := 0; x < 25; x++ {
+						NGramChain.processNgram(tr)
+					}
+					wg.Done()
+				}(trigram)
+			}
+
+			// spawn the document writers
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(i int) {
+					NGramChain.ProcessText(strings.NewReader(strings.Repeat(fmt.Sprintf("x%d y%d z%d ", i, i, i), 25)))
+					wg.Done()
+				}(i)
+			}
+
+			// spawn the readers
+			for i := 0; i < 75; i++ {
+				wg.Add(1)
+				go func() {
+					NGramChain.GenerateRandomText(100)
+					wg.Done()
+				}()
+			}
 
-	var wg = &sync.WaitGroup{}
+			wg.Wait()
 
-	// spawn the writers
-	for _, trigram := range trigrams {
-		wg.Add(1)
-		go func(tr []string) {
+			var want, _ = NewNGramChain(3)
 			for x := 0; x < 25; x++ {
-				NGramChain.processNgram(tr)
+				for _, trigram := range trigrams {
+					want.processNgram(trigram)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				want.ProcessText(strings.NewReader(strings.Repeat(fmt.Sprintf("x%d y%d z%d ", i, i, i), 25)))
+			}
+
+			var wantEntries = sortedEntries(want.chain)
+			var entries = sortedEntries(NGramChain.chain)
+			for _, entries := range [][]testEntry{entries, wantEntries} {
+				for _, entry := range entries {
+					sort.Slice(entry.candidates, func(i, j int) bool {
+						return entry.candidates[i].word < entry.candidates[j].word
+					})
+				}
+			}
+
+			if !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", pretty.Sprint(entries), pretty.Sprint(wantEntries))
 			}
-			wg.Done()
-		}(trigram)
+		})
 	}
+}
 
-	// spawn the readers
-	for i := 0; i < 75; i++ {
-		wg.Add(1)
-		go func() {
-			NGramChain.GenerateRandomText(100)
-			wg.Done()
-		}()
+func BenchmarkNGramChain_ProcessText(b *testing.B) {
+	// every "of the" prefix is followed by a different word, like in large
+	// corpora where common prefixes have thousands of followers
+	var text strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&text, "of the word%d ", i)
 	}
 
-	wg.Wait()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var chain, _ = NewNGramChain(3)
+		chain.ProcessText(strings.NewReader(text.String()))
+	}
+}
 
-	var wantMap = map[string]*candidates{
-		"a a": &candidates{
-			words: []wordFrequency{
-				{word: "b", frequency: 25},
-				{word: "c", frequency: 25},
-				{word: "d", frequency: 25},
-			},
-			occurrences: 75,
-		},
+func BenchmarkNGramChain_ProcessText_parallel(b *testing.B) {
+	// the corpus is split in documents ingested by different goroutines
+	var corpus = strings.Fields(getBenchmarkCorpus())[:benchmarkCorpusTokens/4]
+	var documents []string
+	for i := 0; i < len(corpus); i += 1000 {
+		documents = append(documents, strings.Join(corpus[i:i+1000], " "))
 	}
 
-	var words = NGramChain.store["a a"].words
-	sort.Slice(words, func(i, j int) bool {
-		return words[i].word < words[j].word
+	for _, store := range []struct {
+		name string
+		opts func() []Option
+	}{
+		{name: "map", opts: func() []Option { return nil }},
+		{name: "sharded", opts: func() []Option { return []Option{WithStore(NewShardedStore(0))} }},
+	} {
+		for _, workers := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("%s/workers=%d", store.name, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					var chain, _ = NewNGramChain(3, store.opts()...)
+
+					var next atomic.Int64
+					var wg sync.WaitGroup
+					for w := 0; w < workers; w++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							for d := next.Add(1) - 1; d < int64(len(documents)); d = next.Add(1) - 1 {
+								chain.ProcessText(strings.NewReader(documents[d]))
+							}
+						}()
+					}
+					wg.Wait()
+				}
+			})
+		}
+	}
+}
+
+// testEntry is the readable state of a prefix of a chain store and its
+// candidates
+type testEntry struct {
+	prefix     []string
+	candidates []testCandidate
+}
+
+// testCandidate is the readable state of a candidate and its frequency
+type testCandidate struct {
+	word      string
+	frequency int
+}
+
+// newTestChain returns a chain with deterministic randomness and the given
+// entries and seeds, added in order
+func newTestChain(n uint, entries []testEntry, seeds ...[]string) NGramChain {
+	var chain, _ = NewNGramChain(n, WithSeedPolicy(NoSeeds))
+	chain.chain.randFunc = dummyRandFunc
+	chain.chain.seedPolicy = UppercaseSeeds
+
+	for _, entry := range entries {
+		var key = chain.chain.internKey(entry.prefix)
+		for _, candidate := range entry.candidates {
+			chain.chain.add(key, chain.chain.symbols.intern(candidate.word), candidate.frequency)
+		}
+	}
+
+	for _, seed := range seeds {
+		chain.chain.seeds = append(chain.chain.seeds, chain.chain.internKey(seed))
+	}
+
+	return *chain
+}
+
+// storeEntries returns the readable state of the chain store, in insertion
+// order
+func storeEntries(c *Chain[string]) []testEntry {
+	var entries []testEntry
+	c.store.each(func(key string, candidates *candidates) bool {
+		var entry = testEntry{prefix: c.symbols.symbols(unpackKey(key))}
+		for _, wf := range candidates.words {
+			entry.candidates = append(entry.candidates, testCandidate{word: c.symbols.value(wf.word), frequency: wf.frequency})
+		}
+		entries = append(entries, entry)
+		return true
+	})
+
+	return entries
+}
+
+// sortedEntries returns the readable state of the chain store, sorted by
+// prefix
+func sortedEntries(c *Chain[string]) []testEntry {
+	var entries = storeEntries(c)
+	sort.Slice(entries, func(i, j int) bool {
+		return slices.Compare(entries[i].prefix, entries[j].prefix) < 0
 	})
 
-	if !reflect.DeepEqual(NGramChain.store, wantMap) {
-		t.Errorf("got %v, want %v", pretty.Sprint(NGramChain.store), pretty.Sprint(wantMap))
+	return entries
+}
+
+// seedTokens returns the readable state of the chain seeds
+func seedTokens(c *Chain[string]) [][]string {
+	var seeds [][]string
+	for _, seed := range c.seeds {
+		seeds = append(seeds, c.symbols.symbols(unpackKey(seed)))
 	}
+
+	return seeds
 }
 
 func getValidChain() NGramChain {
-	return NGramChain{
-		store: map[string]*candidates{
-			"I am": &candidates{
-				words: []wordFrequency{
-					{word: "batman", frequency: 4},
-				},
-				occurrences: 4,
-			},
-		},
-		seeds:    []string{"I am"},
-		randFunc: dummyRandFunc,
-		lock:     &sync.RWMutex{},
-		n:        3,
-	}
+	return newTestChain(3, []testEntry{
+		{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 4}}},
+	}, []string{"I", "am"})
 }
 
 func Example() {
@@ -0,0 +1,301 @@
+package markov
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"unicode/utf8"
+)
+
+// Option configures a chain on construction. The options about text, like the
+// tokenizers, only apply to NGramChain.
+type Option func(*config) error
+
+// config holds the settings of a chain, as set by its options
+type config struct {
+	tokenizer    Tokenizer
+	detokenizer  Detokenizer
+	maxTokenSize int
+	oversize     OversizePolicy
+
+	// bounded chains process their input as sequences padded with start and
+	// end boundaries. When sentences is set, every sentence is a sequence
+	bounded   bool
+	sentences bool
+
+	caseFolding bool
+	randFunc    func(n int) int
+	minCount    int
+	seedPolicy  SeedPolicy
+	smoothing   Smoothing
+	backoff     Backoff
+
+	// store is nil for the default map. symbolStore is set when the store
+	// also keeps the symbols
+	store       ngramStore
+	symbolStore SymbolStore
+}
+
+// newConfig returns the default settings of a chain of order n with the
+// options applied: the text is split in words, upper case prefixes are used as
+// seeds, the randomness comes from the math/rand global source and the ngrams
+// are kept in a map
+func newConfig(n uint, opts []Option) (*config, error) {
+	var cfg = &config{
+		tokenizer:    WordTokenizer{},
+		maxTokenSize: bufio.MaxScanTokenSize,
+		randFunc:     rand.Intn,
+		minCount:     1,
+		seedPolicy:   UppercaseSeeds,
+	}
+
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.smoothing != nil && cfg.backoff != nil {
+		return nil, errors.New("smoothing and backoff can't be combined")
+	}
+
+	// every chain gets its own interpolation, since its lambdas can be fitted
+	if interpolation, ok := cfg.backoff.(*interpolation); ok {
+		var err error
+		if cfg.backoff, err = interpolation.forOrder(n); err != nil {
+			return nil, err
+		}
+	}
+
+	// if no detokenizer was provided, use the tokenizer if it knows how to join
+	// the tokens back
+	if cfg.detokenizer == nil {
+		cfg.detokenizer = WordTokenizer{}
+		if detokenizer, ok := cfg.tokenizer.(Detokenizer); ok {
+			cfg.detokenizer = detokenizer
+		}
+	}
+
+	return cfg, nil
+}
+
+// WithTokenizer sets the Tokenizer used to split the processed text and the
+// prefixes on input. If t also implements Detokenizer, it will be used to join
+// the generated text unless WithDetokenizer is provided. Defaults to
+// WordTokenizer.
+func WithTokenizer(t Tokenizer) Option {
+	return func(c *config) error {
+		if t == nil {
+			return errors.New("tokenizer can't be nil")
+		}
+
+		c.tokenizer = t
+		return nil
+	}
+}
+
+// WithDetokenizer sets the Detokenizer used to join the generated text
+func WithDetokenizer(d Detokenizer) Option {
+	return func(c *config) error {
+		if d == nil {
+			return errors.New("detokenizer can't be nil")
+		}
+
+		c.detokenizer = d
+		return nil
+	}
+}
+
+// WithMaxTokenSize sets the max size in bytes of the tokens of the processed
+// text, and what to do with the longer ones. It must be at least
+// utf8.UTFMax, so a token can hold any rune. Defaults to
+// bufio.MaxScanTokenSize and FailOversized.
+func WithMaxTokenSize(size uint, policy OversizePolicy) Option {
+	return func(c *config) error {
+		if size < utf8.UTFMax {
+			return fmt.Errorf("max token size must be at least %d", utf8.UTFMax)
+		}
+
+		if policy < FailOversized || policy > SplitOversized {
+			return fmt.Errorf("unknown oversize policy %d", policy)
+		}
+
+		c.maxTokenSize = int(size)
+		c.oversize = policy
+		return nil
+	}
+}
+
+// WithSentenceBoundaries makes the chain process every sentence on input as a
+// sequence padded with StartToken and EndToken, instead of relying on upper
+// case seeds. Text generation will then start at the beginning of a sentence
+// and stop at its end, which works for any casing or script. A sentence ends
+// with a token ending in a sentence terminal like ".", "?" or "。", or at the
+// end of the input.
+func WithSentenceBoundaries() Option {
+	return func(c *config) error {
+		c.bounded = true
+		c.sentences = true
+		return nil
+	}
+}
+
+// WithSequenceBoundaries makes the chain process its input as sequences padded
+// with start and end boundaries, so generation starts at the beginning of a
+// sequence and stops at its end instead of relying on seeds. Every call to
+// Chain.Add is a sequence. NGramChain processes every text as a sequence, which
+// its tokenizer can split further by emitting EndToken.
+func WithSequenceBoundaries() Option {
+	return func(c *config) error {
+		c.bounded = true
+		return nil
+	}
+}
+
+// WithRand sets the source of randomness used to select the candidates and
+// seeds, so the generated text can be reproduced. The source is guarded by a
+// mutex, since *rand.Rand is not safe for concurrent use, and it shouldn't be
+// used anywhere else. Defaults to the math/rand global source.
+func WithRand(r *rand.Rand) Option {
+	return func(c *config) error {
+		if r == nil {
+			return errors.New("rand can't be nil")
+		}
+
+		var lock sync.Mutex
+		c.randFunc = func(n int) int {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return r.Intn(n)
+		}
+		return nil
+	}
+}
+
+// WithSeed makes the chain use its own source of randomness initialised with
+// the given seed. Chains with the same seed processing the same input will
+// generate the same text, as long as they're not used concurrently.
+func WithSeed(seed int64) Option {
+	return WithRand(rand.New(rand.NewSource(seed)))
+}
+
+// WithCaseFolding makes the chain case insensitive by lower casing every
+// token processed, as well as the prefixes and candidates on input. Note the
+// default seed policy relies on upper case prefixes, so it's better combined
+// with WithSentenceBoundaries or another SeedPolicy.
+func WithCaseFolding() Option {
+	return func(c *config) error {
+		c.caseFolding = true
+		return nil
+	}
+}
+
+// WithMinCount makes the chain ignore the candidates seen less than minCount
+// times for a given prefix, both when generating text and computing
+// probabilities. It's useful to filter out noise from large corpora. Defaults
+// to 1, which keeps every candidate.
+func WithMinCount(minCount uint) Option {
+	return func(c *config) error {
+		if minCount == 0 {
+			return errors.New("min count must be at least 1")
+		}
+
+		c.minCount = int(minCount)
+		return nil
+	}
+}
+
+// WithSeedPolicy sets the policy deciding which prefixes are used as seeds to
+// start generating text. Defaults to UppercaseSeeds. It's ignored by chains
+// with sentence boundaries, which always start at the beginning of a sentence,
+// and it only applies to NGramChain.
+func WithSeedPolicy(policy SeedPolicy) Option {
+	return func(c *config) error {
+		if policy == nil {
+			return errors.New("seed policy can't be nil")
+		}
+
+		c.seedPolicy = policy
+		return nil
+	}
+}
+
+// WithSmoothing sets the smoothing used to estimate the probability of unseen
+// ngrams. Probabilities and scores then account for unseen candidates and
+// prefixes, instead of returning 0 or an error for them. Defaults to no
+// smoothing.
+func WithSmoothing(smoothing Smoothing) Option {
+	return func(c *config) error {
+		if smoothing == nil {
+			return errors.New("smoothing can't be nil")
+		}
+
+		switch s := smoothing.(type) {
+		case addK:
+			if !(s.k > 0) || math.IsInf(s.k, 1) {
+				return fmt.Errorf("additive smoothing k must be positive, got %v", s.k)
+			}
+		case absoluteDiscounting:
+			if !(s.d > 0 && s.d <= 1) {
+				return fmt.Errorf("absolute discounting d must be in (0, 1], got %v", s.d)
+			}
+		}
+
+		c.smoothing = smoothing
+		return nil
+	}
+}
+
+// WithBackoff makes the chain train every ngram order from 1 to n, and use
+// the given backoff to fall back to shorter prefixes when a prefix is unknown,
+// both when generating text and computing probabilities. It can't be combined
+// with WithSmoothing. Besides StupidBackoff and KatzBackoff, it accepts an
+// Interpolation, which mixes every order instead. Defaults to no backoff.
+func WithBackoff(backoff Backoff) Option {
+	return func(c *config) error {
+		if backoff == nil {
+			return errors.New("backoff can't be nil")
+		}
+
+		if s, ok := backoff.(stupidBackoff); ok && !(s.alpha > 0 && s.alpha <= 1) {
+			return fmt.Errorf("stupid backoff alpha must be in (0, 1], got %v", s.alpha)
+		}
+
+		c.backoff = backoff
+		return nil
+	}
+}
+
+// WithTrieStore makes the chain keep its prefixes in a trie of symbol IDs
+// instead of a map, so the prefixes sharing their first symbols share memory.
+// Every lookup walks the trie, one symbol at a time, but it takes less memory
+// for large corpora and n. Defaults to a map.
+func WithTrieStore() Option {
+	return func(c *config) error {
+		c.store = newTrieStore()
+		c.symbolStore = nil
+		return nil
+	}
+}
+
+// WithStore makes the chain keep its ngrams in the given store, which must not
+// be used by anything else afterwards. If the store already has ngrams, they
+// must have n-1 symbols and the store must be a SymbolStore, so the chain can
+// know their symbols, like the ones opened with OpenMappedStore and
+// OpenDiskStore. Chains with a store backed by a file can't Load other chains.
+// Defaults to NewMapStore.
+func WithStore(store Store) Option {
+	return func(c *config) error {
+		if store == nil {
+			return errors.New("store can't be nil")
+		}
+
+		c.store = asNgramStore(store)
+		c.symbolStore, _ = store.(SymbolStore)
+		return nil
+	}
+}
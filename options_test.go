@@ -0,0 +1,302 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWithSeed_reproducible(t *testing.T) {
+	t.Parallel()
+
+	var text = `I am batman. I am groot. I am your father. It's a trap. It's a
+	wonderful world. We live in a wonderful planet. I am the one who knocks.`
+
+	var generate = func(seed int64) []string {
+		var chain, _ = NewNGramChain(2, WithSeed(seed))
+		chain.ProcessText(strings.NewReader(text))
+
+		var outputs []string
+		for i := 0; i < 10; i++ {
+			outputs = append(outputs, chain.GenerateRandomText(20), chain.GetCandidate("a"))
+		}
+		return outputs
+	}
+
+	var first, second = generate(42), generate(42)
+	if strings.Join(first, "|") != strings.Join(second, "|") {
+		t.Errorf("got %q, want %q", second, first)
+	}
+}
+
+func TestWithSeed_uniqueKeysSelection(t *testing.T) {
+	t.Parallel()
+
+	// without seeds the ngram is selected from all the keys, which must not
+	// depend on the map iteration order
+	var generate = func() string {
+		var chain, _ = NewNGramChain(2, WithSeed(7))
+		chain.ProcessText(strings.NewReader("a b c d e f g h i j k l m n o p"))
+		return chain.GenerateRandomText(3)
+	}
+
+	var want = generate()
+	for i := 0; i < 10; i++ {
+		if got := generate(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWithRand_concurrency(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2, WithRand(rand.New(rand.NewSource(1))))
+	chain.ProcessText(strings.NewReader("a b a c a d a e"))
+
+	var wg = &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chain.GenerateRandomText(10)
+			chain.GetCandidate("a")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithCaseFolding(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithCaseFolding())
+	chain.ProcessText(strings.NewReader("I am Batman. i AM batman."))
+
+	var wantEntries = []testEntry{
+		{prefix: []string{"i", "am"}, candidates: []testCandidate{{"batman.", 2}}},
+		{prefix: []string{"am", "batman."}, candidates: []testCandidate{{"i", 1}}},
+		{prefix: []string{"batman.", "i"}, candidates: []testCandidate{{"am", 1}}},
+	}
+
+	if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+
+	if candidate := chain.GetCandidate("I AM"); candidate != "batman." {
+		t.Errorf("got %v, want %v", candidate, "batman.")
+	}
+
+	if probability, _ := chain.CandidateProbability("I Am", "BATMAN."); probability != 1 {
+		t.Errorf("got %v, want %v", probability, 1)
+	}
+}
+
+func TestWithMinCount(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		prefix    string
+		candidate string
+
+		wantCandidate   string
+		wantProbability float32
+		wantErr         error
+	}{
+		{
+			name:            "ok - frequent candidate",
+			prefix:          "I am",
+			candidate:       "batman",
+			wantCandidate:   "batman",
+			wantProbability: 1,
+			wantErr:         nil,
+		},
+		{
+			name:            "ok - infrequent candidate",
+			prefix:          "I am",
+			candidate:       "groot",
+			wantCandidate:   "batman",
+			wantProbability: 0,
+			wantErr:         nil,
+		},
+		{
+			name:            "ok - infrequent prefix",
+			prefix:          "am batman",
+			candidate:       "and",
+			wantCandidate:   "",
+			wantProbability: 0,
+			wantErr:         errors.New("prefix does not exist"),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(3, WithMinCount(2), WithSeed(1))
+			chain.ProcessText(strings.NewReader("I am batman and I am batman or I am groot"))
+
+			if candidate := chain.GetCandidate(tt.prefix); candidate != tt.wantCandidate {
+				t.Errorf("got %v, want %v", candidate, tt.wantCandidate)
+			}
+
+			var probability, err = chain.CandidateProbability(tt.prefix, tt.candidate)
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+
+			if probability != tt.wantProbability {
+				t.Errorf("got %v, want %v", probability, tt.wantProbability)
+			}
+		})
+	}
+}
+
+func TestWithSeedPolicy(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		policy SeedPolicy
+		text   string
+
+		wantSeeds [][]string
+	}{
+		{
+			name:      "uppercase",
+			policy:    UppercaseSeeds,
+			text:      "Ñandú corre. Ana corre.",
+			wantSeeds: [][]string{{"Ana"}},
+		},
+		{
+			name:      "capitalized",
+			policy:    CapitalizedSeeds,
+			text:      "Ñandú corre. Ana corre.",
+			wantSeeds: [][]string{{"Ñandú"}, {"Ana"}},
+		},
+		{
+			name:      "none",
+			policy:    NoSeeds,
+			text:      "Ñandú corre. Ana corre.",
+			wantSeeds: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(2, WithSeedPolicy(tt.policy))
+			chain.ProcessText(strings.NewReader(tt.text))
+
+			if seeds := seedTokens(chain.chain); !reflect.DeepEqual(seeds, tt.wantSeeds) {
+				t.Errorf("got %v, want %v", seeds, tt.wantSeeds)
+			}
+		})
+	}
+}
+
+func TestOptions_invalid(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		opts []Option
+
+		wantErr error
+	}{
+		{
+			name:    "nil rand",
+			opts:    []Option{WithRand(nil)},
+			wantErr: errors.New("error initialising NGramChain: rand can't be nil"),
+		},
+		{
+			name:    "zero min count",
+			opts:    []Option{WithMinCount(0)},
+			wantErr: errors.New("error initialising NGramChain: min count must be at least 1"),
+		},
+		{
+			name:    "max token size smaller than a rune",
+			opts:    []Option{WithMaxTokenSize(3, SkipOversized)},
+			wantErr: errors.New("error initialising NGramChain: max token size must be at least 4"),
+		},
+		{
+			name:    "unknown oversize policy",
+			opts:    []Option{WithMaxTokenSize(64, OversizePolicy(7))},
+			wantErr: errors.New("error initialising NGramChain: unknown oversize policy 7"),
+		},
+		{
+			name:    "nil seed policy",
+			opts:    []Option{WithSeedPolicy(nil)},
+			wantErr: errors.New("error initialising NGramChain: seed policy can't be nil"),
+		},
+		{
+			name:    "nil smoothing",
+			opts:    []Option{WithSmoothing(nil)},
+			wantErr: errors.New("error initialising NGramChain: smoothing can't be nil"),
+		},
+		{
+			name:    "zero additive smoothing",
+			opts:    []Option{WithSmoothing(AddK(0))},
+			wantErr: errors.New("error initialising NGramChain: additive smoothing k must be positive, got 0"),
+		},
+		{
+			name:    "NaN additive smoothing",
+			opts:    []Option{WithSmoothing(AddK(math.NaN()))},
+			wantErr: errors.New("error initialising NGramChain: additive smoothing k must be positive, got NaN"),
+		},
+		{
+			name:    "negative absolute discount",
+			opts:    []Option{WithSmoothing(AbsoluteDiscounting(-0.5))},
+			wantErr: errors.New("error initialising NGramChain: absolute discounting d must be in (0, 1], got -0.5"),
+		},
+		{
+			name:    "absolute discount over 1",
+			opts:    []Option{WithSmoothing(AbsoluteDiscounting(1.5))},
+			wantErr: errors.New("error initialising NGramChain: absolute discounting d must be in (0, 1], got 1.5"),
+		},
+		{
+			name:    "zero stupid backoff alpha",
+			opts:    []Option{WithBackoff(StupidBackoff(0))},
+			wantErr: errors.New("error initialising NGramChain: stupid backoff alpha must be in (0, 1], got 0"),
+		},
+		{
+			name:    "stupid backoff alpha over 1",
+			opts:    []Option{WithBackoff(StupidBackoff(2))},
+			wantErr: errors.New("error initialising NGramChain: stupid backoff alpha must be in (0, 1], got 2"),
+		},
+		{
+			name:    "nil backoff",
+			opts:    []Option{WithBackoff(nil)},
+			wantErr: errors.New("error initialising NGramChain: backoff can't be nil"),
+		},
+		{
+			name:    "nil store",
+			opts:    []Option{WithStore(nil)},
+			wantErr: errors.New("error initialising NGramChain: store can't be nil"),
+		},
+		{
+			name:    "smoothing and backoff",
+			opts:    []Option{WithSmoothing(Laplace()), WithBackoff(KatzBackoff())},
+			wantErr: errors.New("error initialising NGramChain: smoothing and backoff can't be combined"),
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var _, err = NewNGramChain(2, tt.opts...)
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
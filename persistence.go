@@ -0,0 +1,364 @@
+package markov
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// formatMagic identifies the binary encoding of an NGramChain
+var formatMagic = [4]byte{'M', 'K', 'V', 'C'}
+
+// formatVersion is the current version of the binary encoding. It's written
+// on every Save and checked on every Load.
+//
+// Version 1 encoded the seeds as space joined strings. Version 2 encodes them
+// as a list of tokens, like the prefixes, so tokens can contain spaces.
+// Version 3 records whether the chain has sequence boundaries.
+const formatVersion = 3
+
+// checksumSize is the length of the CRC-32 trailer closing every encoding
+const checksumSize = 4
+
+var (
+	// ErrInvalidFormat is returned by Load and UnmarshalJSON when the input is
+	// not a valid encoding of an NGramChain
+	ErrInvalidFormat = errors.New("invalid format")
+	// ErrUnsupportedVersion is returned by Load when the input was encoded
+	// with a version of the format this package doesn't know about
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	// ErrChecksumMismatch is returned by Load when the input checksum doesn't
+	// match its content, usually because the data got corrupted
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrOrderMismatch is returned by Load and UnmarshalJSON when the input was
+	// saved by a chain processing ngrams of a different length than the receiver
+	ErrOrderMismatch = errors.New("ngram order mismatch")
+	// ErrBoundariesMismatch is returned by Load and UnmarshalJSON when the
+	// input was saved by a chain with sequence boundaries and the receiver has
+	// none, or the other way around
+	ErrBoundariesMismatch = errors.New("sequence boundaries mismatch")
+)
+
+// Save will write the chain to w using a versioned binary format which can be
+// read back with Load. The output is deterministic for a given chain state and
+// it's closed by a checksum of its content.
+//
+// The layout is:
+//
+//	magic    "MKVC"
+//	version  uvarint
+//	n        uvarint
+//	bounded  uvarint, 1 if the chain has sequence boundaries and 0 otherwise
+//	seeds    uvarint count, followed by each seed as a token list
+//	store    uvarint count, followed by each entry as:
+//	           prefix     token list, shorter than n-1 tokens for the lower
+//	                      order ngrams of chains with backoff
+//	           candidates uvarint count, followed by each candidate as a
+//	                      string and its uvarint frequency
+//	checksum CRC-32 (IEEE) of all the previous bytes, big endian
+//
+// where strings are encoded as their uvarint length followed by their bytes and
+// token lists as their uvarint count followed by each token as a string.
+func (c *NGramChain) Save(w io.Writer) error {
+	c.chain.lock.RLock()
+	defer c.chain.lock.RUnlock()
+
+	var bw = bufio.NewWriter(w)
+	var enc = newEncoder(bw)
+
+	enc.write(formatMagic[:])
+	enc.uvarint(formatVersion)
+	enc.uvarint(uint64(c.chain.n))
+	if c.chain.bounded {
+		enc.uvarint(1)
+	} else {
+		enc.uvarint(0)
+	}
+
+	enc.uvarint(uint64(len(c.chain.seeds)))
+	for _, seed := range c.chain.seeds {
+		enc.tokens(c.tokens(seed))
+	}
+
+	// sort the prefixes so the same chain always produces the same output
+	var prefixes = c.sortedKeys()
+	if err := storeErr(c.chain.store); err != nil {
+		return fmt.Errorf("error saving NGramChain: %w", err)
+	}
+
+	enc.uvarint(uint64(len(prefixes)))
+	for _, prefix := range prefixes {
+		enc.tokens(c.tokens(prefix))
+
+		var candidates, err = c.chain.stored(prefix)
+		if err != nil {
+			return fmt.Errorf("error saving NGramChain: %w", err)
+		}
+
+		enc.uvarint(uint64(len(candidates.words)))
+		for _, wf := range candidates.words {
+			enc.string(c.chain.symbols.value(wf.word))
+			enc.uvarint(uint64(wf.frequency))
+		}
+	}
+
+	if enc.err != nil {
+		return fmt.Errorf("error saving NGramChain: %w", enc.err)
+	}
+
+	// the checksum is not part of its own input, so write it straight to the
+	// buffered writer
+	var checksum [checksumSize]byte
+	binary.BigEndian.PutUint32(checksum[:], enc.crc.Sum32())
+	if _, err := bw.Write(checksum[:]); err != nil {
+		return fmt.Errorf("error saving NGramChain: %w", err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error saving NGramChain: %w", err)
+	}
+
+	return nil
+}
+
+// Load will read a chain previously written by Save from r and replace the
+// content of the receiver with it. The input must have been saved by a chain
+// with the same n and sequence boundaries, and with backoff if it has lower
+// order ngrams. The receiver is only modified if the whole input is valid, and
+// it must not have a store backed by a file.
+func (c *NGramChain) Load(r io.Reader) error {
+	var data, err = io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error loading NGramChain: %w", err)
+	}
+
+	if len(data) < len(formatMagic)+checksumSize {
+		return fmt.Errorf("error loading NGramChain: %w: input too short", ErrInvalidFormat)
+	}
+
+	if !bytes.Equal(data[:len(formatMagic)], formatMagic[:]) {
+		return fmt.Errorf("error loading NGramChain: %w: unknown magic %q", ErrInvalidFormat, data[:len(formatMagic)])
+	}
+
+	var content, trailer = data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(trailer) {
+		return fmt.Errorf("error loading NGramChain: %w", ErrChecksumMismatch)
+	}
+
+	var chain, decodeErr = c.decode(content[len(formatMagic):])
+	if decodeErr != nil {
+		return fmt.Errorf("error loading NGramChain: %w", decodeErr)
+	}
+
+	c.chain.replace(chain)
+
+	return nil
+}
+
+// decode will parse the body of an encoded chain, that is, everything between
+// the magic and the checksum. It returns a new chain with the decoded content,
+// keeping the prefixes in the order they were read
+func (c *NGramChain) decode(body []byte) (*Chain[string], error) {
+	var dec = &decoder{r: bytes.NewReader(body)}
+	var n = c.chain.n
+
+	var version = dec.uvarint()
+	if dec.err == nil && (version == 0 || version > formatVersion) {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	var inputN = dec.uvarint()
+	if dec.err == nil && inputN != uint64(n) {
+		return nil, fmt.Errorf("%w: chain has n %d, input has n %d", ErrOrderMismatch, n, inputN)
+	}
+
+	// older versions don't record the boundaries, so they can't be checked
+	if version >= 3 {
+		var bounded = dec.uvarint()
+		if dec.err == nil && bounded > 1 {
+			return nil, fmt.Errorf("%w: invalid boundaries flag %d", ErrInvalidFormat, bounded)
+		}
+		if dec.err == nil && (bounded == 1) != c.chain.bounded {
+			return nil, fmt.Errorf("%w: chain has boundaries %t, input has boundaries %t",
+				ErrBoundariesMismatch, c.chain.bounded, bounded == 1)
+		}
+	}
+
+	var seedCount = dec.count()
+	var seeds = make([][]string, 0, seedCount)
+	for i := 0; i < seedCount; i++ {
+		if version == 1 {
+			seeds = append(seeds, strings.Split(dec.string(), " "))
+			continue
+		}
+		seeds = append(seeds, dec.tokens())
+	}
+
+	// the loaded chain keeps the same kind of store
+	var store = c.chain.store.empty()
+	if store == nil {
+		return nil, errStoreNotReplaceable
+	}
+
+	var chain = newTextChain(n, &config{backoff: c.chain.backoff, store: store})
+
+	var entryCount = dec.count()
+	for i := 0; i < entryCount && dec.err == nil; i++ {
+		var tokens = dec.tokens()
+		if dec.err == nil && !chain.validPrefix(tokens) {
+			return nil, fmt.Errorf("%w: prefix with %d tokens, expected %d", ErrInvalidFormat, len(tokens), n-1)
+		}
+
+		var prefix = chain.internKey(tokens)
+		if _, exists := chain.storeFor(prefix).get(prefix); exists {
+			return nil, fmt.Errorf("%w: duplicated prefix %q", ErrInvalidFormat, tokens)
+		}
+
+		var wordCount = dec.count()
+		if dec.err == nil && wordCount == 0 {
+			return nil, fmt.Errorf("%w: prefix %q with no candidates", ErrInvalidFormat, tokens)
+		}
+
+		var seen = make(map[string]bool, wordCount)
+		for j := 0; j < wordCount; j++ {
+			var word = dec.string()
+			var frequency = dec.uvarint()
+			if dec.err == nil && frequency == 0 {
+				return nil, fmt.Errorf("%w: candidate %q with no occurrences", ErrInvalidFormat, word)
+			}
+
+			if dec.err == nil && seen[word] {
+				return nil, fmt.Errorf("%w: duplicated candidate %q for prefix %q", ErrInvalidFormat, word, tokens)
+			}
+			seen[word] = true
+
+			if _, err := chain.add(prefix, chain.symbols.intern(word), int(frequency)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFormat, dec.err)
+	}
+
+	if dec.r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d unexpected trailing bytes", ErrInvalidFormat, dec.r.Len())
+	}
+
+	for _, seed := range seeds {
+		var key = chain.key(seed)
+		if _, exists := chain.store.get(key); !exists {
+			return nil, fmt.Errorf("%w: seed %q is not a known prefix", ErrInvalidFormat, seed)
+		}
+		chain.seeds = append(chain.seeds, key)
+	}
+
+	return chain, nil
+}
+
+// encoder writes the primitives of the binary format while keeping a running
+// checksum of the output. The first error is kept and any later write is a
+// no-op, so it only needs to be checked once at the end.
+type encoder struct {
+	w   io.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func newEncoder(w io.Writer) *encoder {
+	var crc = crc32.NewIEEE()
+	return &encoder{w: io.MultiWriter(w, crc), crc: crc}
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var l = binary.PutUvarint(e.buf[:], v)
+	e.write(e.buf[:l])
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *encoder) tokens(tokens []string) {
+	e.uvarint(uint64(len(tokens)))
+	for _, token := range tokens {
+		e.string(token)
+	}
+}
+
+// decoder reads the primitives of the binary format. Like the encoder, it
+// keeps the first error and returns zero values afterwards.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	var v, err = binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = fmt.Errorf("error reading varint: %w", err)
+	}
+
+	return v
+}
+
+// count reads a length prefix, making sure it's not larger than the bytes left
+// on input so corrupted counts can't trigger huge allocations
+func (d *decoder) count() int {
+	var v = d.uvarint()
+	if d.err == nil && v > uint64(d.r.Len()) {
+		d.err = fmt.Errorf("count %d exceeds the remaining %d bytes", v, d.r.Len())
+	}
+
+	if d.err != nil {
+		return 0
+	}
+
+	return int(v)
+}
+
+func (d *decoder) string() string {
+	var l = d.count()
+	if d.err != nil {
+		return ""
+	}
+
+	var buf = make([]byte, l)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		d.err = fmt.Errorf("error reading string: %w", err)
+		return ""
+	}
+
+	return string(buf)
+}
+
+func (d *decoder) tokens() []string {
+	var count = d.count()
+
+	var tokens = make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		tokens = append(tokens, d.string())
+	}
+
+	return tokens
+}
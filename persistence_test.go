@@ -0,0 +1,363 @@
+package markov
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNGramChain_SaveLoad(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name  string
+		chain func() NGramChain
+	}{
+		{
+			name:  "ok - valid chain",
+			chain: getValidChain,
+		},
+		{
+			name: "ok - multiple prefixes and candidates",
+			chain: func() NGramChain {
+				var c = getValidChain()
+				c.processNgram([]string{"I", "am", "groot"})
+				c.processNgram([]string{"am", "groot", "and"})
+				c.processNgram([]string{"It's", "a", "trap"})
+				return c
+			},
+		},
+		{
+			name: "ok - empty chain",
+			chain: func() NGramChain {
+				var c, _ = NewNGramChain(3)
+				return *c
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain = tt.chain()
+
+			var buf bytes.Buffer
+			if err := chain.Save(&buf); err != nil {
+				t.Fatalf("unexpected error saving: %v", err)
+			}
+
+			var loaded, _ = NewNGramChain(3)
+			if err := loaded.Load(&buf); err != nil {
+				t.Fatalf("unexpected error loading: %v", err)
+			}
+
+			var entries, wantEntries = sortedEntries(loaded.chain), sortedEntries(chain.chain)
+			if !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", entries, wantEntries)
+			}
+
+			var seeds, wantSeeds = seedTokens(loaded.chain), seedTokens(chain.chain)
+			if !reflect.DeepEqual(seeds, wantSeeds) {
+				t.Errorf("got %v, want %v", seeds, wantSeeds)
+			}
+		})
+	}
+}
+
+func TestNGramChain_SaveLoad_boundaries(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithSentenceBoundaries())
+	chain.ProcessText(strings.NewReader("You are robin."))
+
+	var buf bytes.Buffer
+	if err := chain.Save(&buf); err != nil {
+		t.Fatalf("unexpected error saving: %v", err)
+	}
+
+	var loaded, _ = NewNGramChain(3, WithSentenceBoundaries())
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+
+	var want = "You are robin."
+	if got := loaded.GenerateRandomText(10); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// an unbounded chain can't be loaded into a bounded one either
+	var unbounded, _ = NewNGramChain(3)
+	unbounded.ProcessText(strings.NewReader("You are robin."))
+	buf.Reset()
+	unbounded.Save(&buf)
+	if err := loaded.Load(&buf); !errors.Is(err, ErrBoundariesMismatch) {
+		t.Errorf("got %v, want %v", err, ErrBoundariesMismatch)
+	}
+}
+
+func TestNGramChain_Save_deterministic(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2)
+	chain.ProcessText(strings.NewReader("a b c d e f g h a c"))
+
+	var first, second bytes.Buffer
+	chain.Save(&first)
+	chain.Save(&second)
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("got different outputs for the same chain")
+	}
+}
+
+func TestNGramChain_Load(t *testing.T) {
+	t.Parallel()
+
+	var getValidData = func() []byte {
+		var chain = getValidChain()
+		var buf bytes.Buffer
+		chain.Save(&buf)
+		return buf.Bytes()
+	}
+
+	var tests = []struct {
+		name string
+		n    uint
+		data []byte
+
+		wantErr error
+	}{
+		{
+			name:    "ok",
+			n:       3,
+			data:    getValidData(),
+			wantErr: nil,
+		},
+		{
+			name:    "error - empty input",
+			n:       3,
+			data:    []byte{},
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - invalid magic",
+			n:    3,
+			data: func() []byte {
+				var data = getValidData()
+				copy(data, "JSON")
+				return data
+			}(),
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - corrupted content",
+			n:    3,
+			data: func() []byte {
+				var data = getValidData()
+				data[len(data)/2] ^= 0xff
+				return data
+			}(),
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name: "error - truncated input",
+			n:    3,
+			data: func() []byte {
+				var data = getValidData()
+				return data[:len(data)-1]
+			}(),
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "error - order mismatch",
+			n:       4,
+			data:    getValidData(),
+			wantErr: ErrOrderMismatch,
+		},
+		{
+			name: "error - unsupported version",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(formatVersion + 1)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name: "error - boundaries mismatch",
+			n:    3,
+			data: func() []byte {
+				var chain, _ = NewNGramChain(3, WithSentenceBoundaries())
+				chain.ProcessText(strings.NewReader("You are robin."))
+				var buf bytes.Buffer
+				chain.Save(&buf)
+				return buf.Bytes()
+			}(),
+			wantErr: ErrBoundariesMismatch,
+		},
+		{
+			name: "error - invalid boundaries flag",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(formatVersion)
+				enc.uvarint(3)
+				enc.uvarint(2)
+				enc.uvarint(0)
+				enc.uvarint(0)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "ok - version 2 without boundaries",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(2)
+				enc.uvarint(3)
+				enc.uvarint(1)
+				enc.tokens([]string{"I", "am"})
+				enc.uvarint(1)
+				enc.tokens([]string{"I", "am"})
+				enc.uvarint(1)
+				enc.string("batman")
+				enc.uvarint(4)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: nil,
+		},
+		{
+			name: "ok - version 1 space joined seeds",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(1)
+				enc.uvarint(3)
+				enc.uvarint(1)
+				enc.string("I am")
+				enc.uvarint(1)
+				enc.tokens([]string{"I", "am"})
+				enc.uvarint(1)
+				enc.string("batman")
+				enc.uvarint(4)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: nil,
+		},
+		{
+			name: "error - invalid prefix length",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(formatVersion)
+				enc.uvarint(3)
+				enc.uvarint(0)
+				enc.uvarint(0)
+				enc.uvarint(1)
+				enc.tokens([]string{"I"})
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - duplicated candidate",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(formatVersion)
+				enc.uvarint(3)
+				enc.uvarint(0)
+				enc.uvarint(0)
+				enc.uvarint(1)
+				enc.tokens([]string{"I", "am"})
+				enc.uvarint(2)
+				enc.string("batman")
+				enc.uvarint(1)
+				enc.string("batman")
+				enc.uvarint(3)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - prefix without candidates",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(formatVersion)
+				enc.uvarint(3)
+				enc.uvarint(0)
+				enc.uvarint(0)
+				enc.uvarint(1)
+				enc.tokens([]string{"I", "am"})
+				enc.uvarint(0)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name: "error - unknown seed",
+			n:    3,
+			data: func() []byte {
+				var buf bytes.Buffer
+				var enc = newEncoder(&buf)
+				enc.write(formatMagic[:])
+				enc.uvarint(formatVersion)
+				enc.uvarint(3)
+				enc.uvarint(0)
+				enc.uvarint(1)
+				enc.tokens([]string{"You", "are"})
+				enc.uvarint(0)
+				return appendChecksum(buf.Bytes(), enc)
+			}(),
+			wantErr: ErrInvalidFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(tt.n)
+			chain.ProcessText(strings.NewReader("a b c d e"))
+			var wantEntries = storeEntries(chain.chain)
+
+			var err = chain.Load(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+
+			// the chain must be left untouched on error
+			if entries := storeEntries(chain.chain); err != nil && !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", entries, wantEntries)
+			}
+		})
+	}
+}
+
+// appendChecksum closes the data written through enc with its checksum
+func appendChecksum(data []byte, enc *encoder) []byte {
+	var sum = enc.crc.Sum32()
+	return append(data, byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+}
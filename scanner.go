@@ -0,0 +1,142 @@
+package markov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// OversizePolicy decides what a chain does with the tokens longer than its max
+// token size, set with WithMaxTokenSize
+type OversizePolicy int
+
+const (
+	// FailOversized stops processing the text, returning a TextError wrapping
+	// bufio.ErrTooLong. It's the default policy
+	FailOversized OversizePolicy = iota
+	// SkipOversized drops the oversized tokens and carries on with the text
+	SkipOversized
+	// SplitOversized splits the oversized tokens in tokens of the max size
+	SplitOversized
+)
+
+// TextError is returned when a text can't be read or split in tokens, so the
+// rest of it isn't processed
+type TextError struct {
+	// Offset is the number of bytes of the text split in tokens before the
+	// error
+	Offset int64
+	Err    error
+}
+
+// Error implements error
+func (e *TextError) Error() string {
+	return fmt.Sprintf("error reading text at byte %d: %v", e.Offset, e.Err)
+}
+
+// Unwrap returns the error that stopped the text from being read
+func (e *TextError) Unwrap() error {
+	return e.Err
+}
+
+// textScanner splits a text in tokens with the tokenizer of a chain, applying
+// its oversize policy to the tokens that don't fit in the buffer
+type textScanner struct {
+	*bufio.Scanner
+	*tokenSplitter
+}
+
+// newScanner returns a scanner splitting the text in tokens for the chain
+func (c *NGramChain) newScanner(text io.Reader) *textScanner {
+	var s = &textScanner{Scanner: bufio.NewScanner(text), tokenSplitter: c.newSplitter()}
+	s.Buffer(make([]byte, 0, min(c.maxTokenSize, 4096)), c.maxTokenSize)
+	s.Split(s.splitToken)
+
+	return s
+}
+
+// Err returns the error that stopped the scanner as a TextError, or nil if
+// the text was read until the end
+func (s *textScanner) Err() error {
+	if err := s.Scanner.Err(); err != nil {
+		return &TextError{Offset: s.offset, Err: err}
+	}
+
+	return nil
+}
+
+// tokenSplitter splits data in tokens with the tokenizer of a chain, applying
+// its oversize policy to the tokens longer than its max token size. The data
+// split must never be longer than the max token size, like the buffer of a
+// bufio.Scanner.
+type tokenSplitter struct {
+	split bufio.SplitFunc
+
+	maxTokenSize int
+	oversize     OversizePolicy
+
+	// offset is the number of bytes split so far. skipping is set while the
+	// rest of a skipped token is being dropped
+	offset   int64
+	skipping bool
+}
+
+// newSplitter returns a splitter of the text processed by the chain
+func (c *NGramChain) newSplitter() *tokenSplitter {
+	return &tokenSplitter{split: c.tokenizer.Split, maxTokenSize: c.maxTokenSize, oversize: c.oversize}
+}
+
+// splitToken implements bufio.SplitFunc. When the data is as long as the max
+// token size but the tokenizer needs more, the token is longer than that, so
+// unless the policy is to fail the data is split as if the text ended there.
+// The skipped tokens are dropped along with the tokens continuing them at the
+// beginning of the next data.
+func (s *tokenSplitter) splitToken(data []byte, atEOF bool) (int, []byte, error) {
+	var advance, token, err = s.split(data, atEOF)
+
+	var oversized = advance == 0 && token == nil && err == nil && !atEOF && len(data) >= s.maxTokenSize
+	if oversized && s.oversize != FailOversized {
+		advance, token, err = s.split(data[:fullRunes(data)], true)
+	}
+	s.offset += int64(advance)
+
+	if s.oversize != SkipOversized {
+		return advance, token, err
+	}
+
+	switch {
+	case token != nil && (oversized || (s.skipping && startsData(data, token))):
+		// the token is dropped, along with the next one if it's cut too
+		s.skipping = oversized
+		return advance, nil, err
+	case token != nil || advance > 0:
+		s.skipping = false
+	}
+
+	return advance, token, err
+}
+
+// fullRunes returns the length of data without the bytes of an incomplete rune
+// at its end
+func fullRunes(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+
+		if utf8.FullRune(data[i:]) {
+			return len(data)
+		}
+
+		return i
+	}
+
+	return len(data)
+}
+
+// startsData returns true if the token is the beginning of data, rather than
+// a later part or a copy of it
+func startsData(data, token []byte) bool {
+	return len(data) > 0 && len(token) > 0 && &data[0] == &token[0]
+}
@@ -0,0 +1,149 @@
+package markov
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_textScanner(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		text string
+		opts []Option
+
+		wantTokens []string
+		wantErr    error
+	}{
+		{
+			name:       "tokens within the max size",
+			text:       "I am batman",
+			opts:       []Option{WithMaxTokenSize(8, FailOversized)},
+			wantTokens: []string{"I", "am", "batman"},
+		},
+		{
+			name:       "fail",
+			text:       "I am supercalifragilistic batman",
+			opts:       []Option{WithMaxTokenSize(8, FailOversized)},
+			wantTokens: []string{"I", "am"},
+			wantErr:    &TextError{Offset: 5, Err: bufio.ErrTooLong},
+		},
+		{
+			name:       "fail by default",
+			text:       "I am " + strings.Repeat("a", bufio.MaxScanTokenSize) + " batman",
+			wantTokens: []string{"I", "am"},
+			wantErr:    &TextError{Offset: 5, Err: bufio.ErrTooLong},
+		},
+		{
+			name:       "skip",
+			text:       "I am supercalifragilistic batman",
+			opts:       []Option{WithMaxTokenSize(8, SkipOversized)},
+			wantTokens: []string{"I", "am", "batman"},
+		},
+		{
+			name:       "skip several tokens",
+			text:       "supercalifragilistic I am supercalifragilistic expialidocious batman supercalifragilistic",
+			opts:       []Option{WithMaxTokenSize(8, SkipOversized)},
+			wantTokens: []string{"I", "am", "batman"},
+		},
+		{
+			name:       "skip with punctuation",
+			text:       "I am supercalifragilistic, batman",
+			opts:       []Option{WithMaxTokenSize(8, SkipOversized), WithTokenizer(PunctuationTokenizer{})},
+			wantTokens: []string{"I", "am", ",", "batman"},
+		},
+		{
+			name:       "split",
+			text:       "I am supercalifragilistic batman",
+			opts:       []Option{WithMaxTokenSize(8, SplitOversized)},
+			wantTokens: []string{"I", "am", "supercal", "ifragili", "stic", "batman"},
+		},
+		{
+			name:       "split between runes",
+			text:       "I am ééééééé",
+			opts:       []Option{WithMaxTokenSize(7, SplitOversized)},
+			wantTokens: []string{"I", "am", "ééé", "ééé", "é"},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(2, tt.opts...)
+
+			// the tokens don't depend on how the text is read, but reading long
+			// texts a byte at a time is too slow
+			var readers = []io.Reader{strings.NewReader(tt.text)}
+			if len(tt.text) < bufio.MaxScanTokenSize {
+				readers = append(readers, &oneByteReader{r: strings.NewReader(tt.text)})
+			}
+
+			for _, reader := range readers {
+				var scanner = chain.newScanner(reader)
+
+				var tokens []string
+				for scanner.Scan() {
+					tokens = append(tokens, scanner.Text())
+				}
+
+				if !reflect.DeepEqual(tokens, tt.wantTokens) {
+					t.Errorf("got %q, want %q", tokens, tt.wantTokens)
+				}
+
+				if err := scanner.Err(); !reflect.DeepEqual(err, tt.wantErr) {
+					t.Errorf("got %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+// failingReader returns the text, then fails with err
+type failingReader struct {
+	text *strings.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.text.Len() == 0 {
+		return 0, r.err
+	}
+
+	return r.text.Read(p)
+}
+
+func TestNGramChain_ProcessText_readError(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3)
+	var reader = &failingReader{text: strings.NewReader("I am batman and I am "), err: io.ErrUnexpectedEOF}
+
+	var err = chain.ProcessText(reader)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	var textErr *TextError
+	if !errors.As(err, &textErr) || textErr.Offset != 21 {
+		t.Errorf("got %v, want an error at byte %v", err, 21)
+	}
+
+	// the ngrams read before the error are kept
+	var wantEntries = []testEntry{
+		{prefix: []string{"I", "am"}, candidates: []testCandidate{{"batman", 1}}},
+		{prefix: []string{"am", "batman"}, candidates: []testCandidate{{"and", 1}}},
+		{prefix: []string{"batman", "and"}, candidates: []testCandidate{{"I", 1}}},
+		{prefix: []string{"and", "I"}, candidates: []testCandidate{{"am", 1}}},
+	}
+
+	if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+}
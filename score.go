@@ -0,0 +1,128 @@
+package markov
+
+import (
+	"io"
+	"math"
+)
+
+// Score is the result of evaluating a text under a chain
+type Score struct {
+	// Tokens holds the probability of every token scored, in order. The
+	// first n-1 tokens of a sequence have no prefix and aren't scored, unless
+	// the chain is bounded, in which case the EndToken closing every sequence
+	// is scored too, or has backoff, in which case they're scored with the
+	// shorter prefixes before them.
+	Tokens []TokenScore
+	// LogProbability is the natural logarithm of the probability of the text,
+	// that is, the sum of the log probabilities of its tokens. It's -Inf if
+	// any token has a probability of 0.
+	LogProbability float64
+	// Perplexity is the exponential of the negative average log probability
+	// per token: the lower, the better the chain predicts the text. It's +Inf
+	// if any token has a probability of 0, and 0 if no token was scored.
+	Perplexity float64
+}
+
+// TokenScore is the probability of a token following the n-1 tokens before it
+type TokenScore struct {
+	Token       string
+	Probability float64
+}
+
+// ScoreText will evaluate the text on input under the chain, computing the
+// probability of every token given its prefix. The text is processed like
+// ProcessText does, but the chain is not modified. Tokens following unknown
+// prefixes have a probability of 0, unless the chain is smoothed or has
+// backoff.
+func (c *NGramChain) ScoreText(text io.Reader) (Score, error) {
+	var scanner = c.newScanner(text)
+
+	var s = &scorer{chain: c, unknown: make(map[string]uint32)}
+
+	var window = c.chain.newWindow()
+	window.intern = s.intern
+	window.process = s.process
+
+	for scanner.Scan() {
+		if err := c.push(window, scanner.Text()); err != nil {
+			return Score{}, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Score{}, err
+	}
+
+	if err := window.close(); err != nil {
+		return Score{}, err
+	}
+
+	return s.score(), nil
+}
+
+// scorer computes the probability of the ngrams of a text
+type scorer struct {
+	chain  *NGramChain
+	tokens []TokenScore
+
+	// unknown holds temporary IDs for the tokens the chain has never seen, so
+	// they can go through the window without being added to the chain
+	unknown      map[string]uint32
+	unknownNames []string
+}
+
+// intern returns the chain ID of a known token, or a temporary ID otherwise
+func (s *scorer) intern(token string) uint32 {
+	if id, known := s.chain.chain.symbols.id(token); known {
+		return id
+	}
+
+	if id, seen := s.unknown[token]; seen {
+		return id
+	}
+
+	var id = noID - uint32(len(s.unknownNames))
+	s.unknown[token] = id
+	s.unknownNames = append(s.unknownNames, token)
+
+	return id
+}
+
+// process scores the last token of the ngram
+func (s *scorer) process(ngram []uint32) error {
+	var chain = s.chain.chain
+	var id = ngram[len(ngram)-1]
+
+	chain.lock.RLock()
+	// an error means the prefix doesn't exist, so the token probability is 0
+	var probability, _ = chain.probabilityID(packKey(ngram[:len(ngram)-1]), id)
+	chain.lock.RUnlock()
+
+	s.tokens = append(s.tokens, TokenScore{Token: s.token(id), Probability: probability})
+
+	return nil
+}
+
+// token returns the token with the given chain or temporary ID
+func (s *scorer) token(id uint32) string {
+	if i := noID - id; int64(i) < int64(len(s.unknownNames)) {
+		return s.unknownNames[i]
+	}
+
+	return s.chain.chain.symbols.value(id)
+}
+
+// score aggregates the probabilities of the scored tokens
+func (s *scorer) score() Score {
+	var score = Score{Tokens: s.tokens}
+	if len(s.tokens) == 0 {
+		return score
+	}
+
+	for _, token := range s.tokens {
+		score.LogProbability += math.Log(token.Probability)
+	}
+	score.Perplexity = math.Exp(-score.LogProbability / float64(len(s.tokens)))
+
+	return score
+}
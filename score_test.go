@@ -0,0 +1,130 @@
+package markov
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNGramChain_ScoreText(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name  string
+		n     uint
+		opts  []Option
+		train string
+		text  string
+
+		wantTokens         []TokenScore
+		wantLogProbability float64
+		wantPerplexity     float64
+	}{
+		{
+			name:  "ok - known text",
+			n:     3,
+			train: "I am batman. I am groot.",
+			text:  "I am batman.",
+			wantTokens: []TokenScore{
+				{Token: "batman.", Probability: 0.5},
+			},
+			wantLogProbability: math.Log(0.5),
+			wantPerplexity:     2,
+		},
+		{
+			name:  "ok - unknown tokens",
+			n:     2,
+			train: "I am batman.",
+			text:  "I am joker.",
+			wantTokens: []TokenScore{
+				{Token: "am", Probability: 1},
+				{Token: "joker.", Probability: 0},
+			},
+			wantLogProbability: math.Inf(-1),
+			wantPerplexity:     math.Inf(1),
+		},
+		{
+			name:  "ok - unknown prefix",
+			n:     2,
+			train: "I am batman.",
+			text:  "You are batman.",
+			wantTokens: []TokenScore{
+				{Token: "are", Probability: 0},
+				{Token: "batman.", Probability: 0},
+			},
+			wantLogProbability: math.Inf(-1),
+			wantPerplexity:     math.Inf(1),
+		},
+		{
+			name:  "ok - sentence boundaries",
+			n:     2,
+			opts:  []Option{WithSentenceBoundaries()},
+			train: "i am batman. i am groot.",
+			text:  "i am groot.",
+			wantTokens: []TokenScore{
+				{Token: "i", Probability: 1},
+				{Token: "am", Probability: 1},
+				{Token: "groot.", Probability: 0.5},
+				{Token: EndToken, Probability: 1},
+			},
+			wantLogProbability: math.Log(0.5),
+			wantPerplexity:     math.Pow(2, 0.25),
+		},
+		{
+			name:               "ok - empty text",
+			n:                  2,
+			train:              "I am batman.",
+			text:               "",
+			wantTokens:         nil,
+			wantLogProbability: 0,
+			wantPerplexity:     0,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(tt.n, tt.opts...)
+			chain.ProcessText(strings.NewReader(tt.train))
+			var wantEntries = storeEntries(chain.chain)
+
+			var score, err = chain.ScoreText(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(score.Tokens, tt.wantTokens) {
+				t.Errorf("got %v, want %v", score.Tokens, tt.wantTokens)
+			}
+
+			if !almostEqual(score.LogProbability, tt.wantLogProbability) {
+				t.Errorf("got %v, want %v", score.LogProbability, tt.wantLogProbability)
+			}
+
+			if !almostEqual(score.Perplexity, tt.wantPerplexity) {
+				t.Errorf("got %v, want %v", score.Perplexity, tt.wantPerplexity)
+			}
+
+			// scoring must not modify the chain
+			if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", entries, wantEntries)
+			}
+
+			if _, known := chain.chain.symbols.id("joker."); known {
+				t.Errorf("unknown token added to the chain symbols")
+			}
+		})
+	}
+}
+
+// almostEqual compares floats allowing for rounding errors
+func almostEqual(a, b float64) bool {
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return a == b
+	}
+
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,30 @@
+package markov
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// SeedPolicy decides whether a new n-1gram prefix should be added to the
+// seeds, the prefixes text generation starts from. If the chain has no seeds,
+// generation starts from any prefix.
+type SeedPolicy func(prefix []string) bool
+
+// UppercaseSeeds accepts prefixes starting with an ASCII upper case letter,
+// which usually begin a sentence in Latin scripts. It's the default policy.
+func UppercaseSeeds(prefix []string) bool {
+	return len(prefix[0]) > 0 && prefix[0][0] >= 'A' && prefix[0][0] <= 'Z'
+}
+
+// CapitalizedSeeds accepts prefixes starting with an upper or title case
+// letter of any script
+func CapitalizedSeeds(prefix []string) bool {
+	var r, _ = utf8.DecodeRuneInString(prefix[0])
+	return unicode.IsUpper(r) || unicode.IsTitle(r)
+}
+
+// NoSeeds doesn't accept any prefix, so text generation starts from any of
+// them
+func NoSeeds(prefix []string) bool {
+	return false
+}
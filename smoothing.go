@@ -0,0 +1,331 @@
+package markov
+
+import "math"
+
+// Smoothing estimates the probability of the ngrams a chain has never seen by
+// moving to them some of the probability mass of the seen ones, so unseen
+// candidates and prefixes don't have a probability of 0. It's set with
+// WithSmoothing and used by every probability and scoring method, while text
+// generation keeps sampling the seen candidates only.
+//
+// The vocabulary of the smoothed distributions is every symbol seen as a
+// candidate plus one slot shared by all the unknown symbols.
+type Smoothing interface {
+	// probability returns the smoothed probability of the word following a
+	// prefix with the given candidates, which are nil if the prefix was
+	// never seen
+	probability(stats *ngramStats, prefix *candidates, word uint32) float64
+}
+
+// AddK returns an additive smoothing, which adds k to the frequency of every
+// candidate of every prefix, seen or not. k must be positive.
+func AddK(k float64) Smoothing {
+	return addK{k: k}
+}
+
+// Laplace returns an additive smoothing adding 1 to every frequency
+func Laplace() Smoothing {
+	return addK{k: 1}
+}
+
+// GoodTuring returns a Good-Turing smoothing. The frequencies below 5 are
+// discounted based on how many ngrams have the next frequency, and the
+// probability mass left is split evenly among the unseen candidates.
+func GoodTuring() Smoothing {
+	return goodTuring{}
+}
+
+// AbsoluteDiscounting returns an interpolated absolute discounting smoothing,
+// which subtracts d from every frequency and gives the probability mass left
+// to the unigram distribution of the candidates. d must be in (0, 1], and 0.75
+// usually works well.
+func AbsoluteDiscounting(d float64) Smoothing {
+	return absoluteDiscounting{d: d}
+}
+
+// KneserNey returns an interpolated modified Kneser-Ney smoothing. It's like
+// absolute discounting with three discounts, for the frequencies of 1, 2 and 3
+// or more, estimated from the chain counts. The probability mass left goes to
+// the continuation distribution, in which candidates following many different
+// prefixes are more likely than frequent candidates following only a few.
+func KneserNey() Smoothing {
+	return kneserNey{}
+}
+
+type addK struct {
+	k float64
+}
+
+func (s addK) probability(stats *ngramStats, prefix *candidates, word uint32) float64 {
+	var frequency, total = stats.frequency(prefix, word), 0
+	if prefix != nil {
+		total = prefix.total(stats.minCount)
+	}
+
+	return (float64(frequency) + s.k) / (float64(total) + s.k*float64(stats.vocabulary))
+}
+
+// goodTuringLimit is the frequency from which Good-Turing considers the counts
+// reliable and stops discounting them
+const goodTuringLimit = 5
+
+type goodTuring struct{}
+
+func (goodTuring) probability(stats *ngramStats, prefix *candidates, word uint32) float64 {
+	if prefix == nil {
+		return 1 / float64(stats.vocabulary)
+	}
+
+	var total = float64(prefix.total(stats.minCount))
+	if frequency := stats.frequency(prefix, word); frequency > 0 {
+		return goodTuringDiscount(stats.countOfCounts, frequency) * float64(frequency) / total
+	}
+
+	// split the discounted mass among the unseen candidates
+	var followers, kept = 0, 0.0
+	for _, wf := range prefix.words {
+		if wf.frequency >= stats.minCount {
+			followers++
+			kept += goodTuringDiscount(stats.countOfCounts, wf.frequency) * float64(wf.frequency) / total
+		}
+	}
+
+	var unseen = stats.vocabulary - followers
+	if unseen <= 0 {
+		return 0
+	}
+
+	return math.Max(1-kept, 0) / float64(unseen)
+}
+
+// goodTuringDiscount returns the ratio between the Good-Turing estimate of the
+// frequency and the frequency itself, given the number of ngrams seen every
+// number of times
+func goodTuringDiscount(countOfCounts [goodTuringLimit + 1]int, frequency int) float64 {
+	if frequency >= goodTuringLimit {
+		return 1
+	}
+
+	var nr, nr1 = countOfCounts[frequency], countOfCounts[frequency+1]
+	if nr == 0 || nr1 == 0 {
+		return 1
+	}
+
+	var discount = float64(frequency+1) * float64(nr1) / float64(nr) / float64(frequency)
+	if discount <= 0 || discount > 1 {
+		return 1
+	}
+
+	return discount
+}
+
+type absoluteDiscounting struct {
+	d float64
+}
+
+func (s absoluteDiscounting) probability(stats *ngramStats, prefix *candidates, word uint32) float64 {
+	// the unigram distribution is discounted too, giving the mass left to
+	// the uniform distribution so unknown symbols are covered
+	var lower = 1 / float64(stats.vocabulary)
+	if stats.total > 0 {
+		var discount = math.Min(s.d, 1)
+		lower = math.Max(float64(stats.unigrams[word])-discount, 0)/float64(stats.total) +
+			discount*float64(len(stats.unigrams))/float64(stats.total)*lower
+	}
+
+	if prefix == nil {
+		return lower
+	}
+
+	var total = float64(prefix.total(stats.minCount))
+	var frequency = float64(stats.frequency(prefix, word))
+	var followers = 0
+	for _, wf := range prefix.words {
+		if wf.frequency >= stats.minCount {
+			followers++
+		}
+	}
+
+	var discount = math.Min(s.d, 1)
+	return math.Max(frequency-discount, 0)/total + discount*float64(followers)/total*lower
+}
+
+type kneserNey struct{}
+
+func (kneserNey) probability(stats *ngramStats, prefix *candidates, word uint32) float64 {
+	// continuation distribution, interpolated with the uniform distribution
+	var lower = 1 / float64(stats.vocabulary)
+	if stats.ngrams > 0 {
+		var continuation = stats.continuations[word]
+		var discount = stats.continuationDiscounts[discountIndex(continuation)]
+
+		lower = math.Max(float64(continuation)-discount, 0)/float64(stats.ngrams) +
+			stats.continuationDiscounted/float64(stats.ngrams)*lower
+	}
+
+	if prefix == nil {
+		return lower
+	}
+
+	var discounts = stats.discounts
+	var total = float64(prefix.total(stats.minCount))
+	var frequency = stats.frequency(prefix, word)
+
+	var gamma = 0.0
+	for _, wf := range prefix.words {
+		if wf.frequency >= stats.minCount {
+			gamma += discounts[discountIndex(wf.frequency)]
+		}
+	}
+
+	return math.Max(float64(frequency)-discounts[discountIndex(frequency)], 0)/total + gamma/total*lower
+}
+
+// defaultDiscount is used by Kneser-Ney when there's not enough counts to
+// estimate a discount
+const defaultDiscount = 0.75
+
+// kneserNeyDiscounts estimates the discounts for the frequencies of 1, 2 and 3
+// or more from the number of ngrams seen 1 to 4 times. The discount at index 0
+// is for unseen ngrams, which is always 0.
+func kneserNeyDiscounts(countOfCounts [goodTuringLimit + 1]int) [4]float64 {
+	var n1, n2 = float64(countOfCounts[1]), float64(countOfCounts[2])
+	var y = n1 / (n1 + 2*n2)
+
+	var discounts [4]float64
+	for r := 1; r < len(discounts); r++ {
+		var nr, nr1 = float64(countOfCounts[r]), float64(countOfCounts[r+1])
+		var discount = float64(r) - float64(r+1)*y*nr1/nr
+
+		// the discount can't be undefined nor take more than the frequency
+		if math.IsNaN(discount) || math.IsInf(discount, 0) || discount <= 0 || discount > float64(r) {
+			discount = math.Min(defaultDiscount, float64(r))
+		}
+		discounts[r] = discount
+	}
+
+	return discounts
+}
+
+// discountIndex returns the index of the discount for the given frequency
+func discountIndex(frequency int) int {
+	if frequency > 3 {
+		return 3
+	}
+
+	return frequency
+}
+
+// ngramStats are the counts of a whole chain used by the smoothings. They only
+// take into account the candidates seen at least minCount times
+type ngramStats struct {
+	minCount int
+	// vocabulary is the number of distinct candidates, plus one for all the
+	// unknown symbols
+	vocabulary int
+	// total is the sum of the frequencies of every ngram and ngrams the number
+	// of distinct ngrams
+	total  int
+	ngrams int
+	// countOfCounts[r] is the number of ngrams seen r times, up to the Good-Turing limit
+	countOfCounts [goodTuringLimit + 1]int
+	// unigrams is the frequency of every candidate across all the prefixes
+	unigrams map[uint32]int
+	// continuations is the number of distinct prefixes every candidate
+	// follows, and continuationCountOfCounts[r] the number of candidates
+	// following r prefixes
+	continuations             map[uint32]int
+	continuationCountOfCounts [goodTuringLimit + 1]int
+
+	// discounts and continuationDiscounts are the Kneser-Ney discounts of the
+	// ngrams and continuations, and continuationDiscounted the sum of the
+	// discounts taken from every continuation
+	discounts              [4]float64
+	continuationDiscounts  [4]float64
+	continuationDiscounted float64
+
+	// orderCountOfCounts is like countOfCounts for the ngrams of every order,
+	// indexed by the length of their prefix. It's only computed for chains
+	// with backoff
+	orderCountOfCounts [][goodTuringLimit + 1]int
+}
+
+// frequency returns the frequency of the word following the prefix, or 0 if
+// it's below the min count
+func (s *ngramStats) frequency(prefix *candidates, word uint32) int {
+	if prefix == nil {
+		return 0
+	}
+
+	var wf = prefix.getCandidate(word)
+	if wf == nil || wf.frequency < s.minCount {
+		return 0
+	}
+
+	return wf.frequency
+}
+
+// getStats returns the counts used by the smoothings and backoffs, computing
+// them if the chain changed since the last call. The caller must hold the read
+// lock.
+func (c *Chain[T]) getStats() *ngramStats {
+	if stats := c.stats.Load(); stats != nil {
+		return stats
+	}
+
+	var stats = &ngramStats{
+		minCount:      c.minCount,
+		unigrams:      make(map[uint32]int),
+		continuations: make(map[uint32]int),
+	}
+
+	c.store.each(func(_ string, candidates *candidates) bool {
+		for _, wf := range candidates.words {
+			if wf.frequency < c.minCount {
+				continue
+			}
+
+			stats.total += wf.frequency
+			stats.ngrams++
+			if wf.frequency <= goodTuringLimit {
+				stats.countOfCounts[wf.frequency]++
+			}
+
+			stats.unigrams[wf.word] += wf.frequency
+			stats.continuations[wf.word]++
+		}
+		return true
+	})
+
+	for _, continuation := range stats.continuations {
+		if continuation <= goodTuringLimit {
+			stats.continuationCountOfCounts[continuation]++
+		}
+	}
+
+	stats.vocabulary = len(stats.unigrams) + 1
+
+	stats.discounts = kneserNeyDiscounts(stats.countOfCounts)
+	stats.continuationDiscounts = kneserNeyDiscounts(stats.continuationCountOfCounts)
+	for _, continuation := range stats.continuations {
+		stats.continuationDiscounted += stats.continuationDiscounts[discountIndex(continuation)]
+	}
+
+	if c.backoff != nil {
+		stats.orderCountOfCounts = make([][goodTuringLimit + 1]int, c.n)
+		stats.orderCountOfCounts[c.n-1] = stats.countOfCounts
+		c.lower.each(func(key string, candidates *candidates) bool {
+			var counts = &stats.orderCountOfCounts[len(key)/idSize]
+			for _, wf := range candidates.words {
+				if wf.frequency >= c.minCount && wf.frequency <= goodTuringLimit {
+					counts[wf.frequency]++
+				}
+			}
+			return true
+		})
+	}
+
+	c.stats.Store(stats)
+
+	return stats
+}
@@ -0,0 +1,200 @@
+package markov
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const smoothingText = `I am batman. I am groot. I am your father. It's a trap. It's a
+	wonderful world. We live in a wonderful planet. I am the one who knocks. I
+	am batman and I am the night.`
+
+func TestSmoothing_normalised(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		smoothing Smoothing
+	}{
+		{name: "add-k", smoothing: AddK(0.5)},
+		{name: "laplace", smoothing: Laplace()},
+		{name: "good-turing", smoothing: GoodTuring()},
+		{name: "absolute discounting", smoothing: AbsoluteDiscounting(0.75)},
+		{name: "kneser-ney", smoothing: KneserNey()},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(3, WithSmoothing(tt.smoothing))
+			chain.ProcessText(strings.NewReader(smoothingText))
+
+			var stats = chain.chain.getStats()
+
+			// every distribution must add up to 1 over the vocabulary, which
+			// includes a slot for the unknown symbols
+			for _, prefix := range []string{"I am", "a wonderful", "You are"} {
+				var key = chain.prefixKey(prefix)
+
+				var sum = 0.0
+				for word := range stats.unigrams {
+					var probability, err = chain.chain.probabilityID(key, word)
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					sum += probability
+				}
+
+				var unknown, _ = chain.chain.probabilityID(key, noID)
+				if unknown <= 0 {
+					t.Errorf("prefix %q: got %v for unknown symbols, want a positive probability", prefix, unknown)
+				}
+				sum += unknown
+
+				if math.Abs(sum-1) > 1e-9 {
+					t.Errorf("prefix %q: got %v, want %v", prefix, sum, 1)
+				}
+			}
+		})
+	}
+}
+
+func TestSmoothing_AddK(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2, WithSmoothing(Laplace()))
+	chain.ProcessText(strings.NewReader("a b a c"))
+
+	// the vocabulary is a, b, c and the unknown symbols
+	var tests = []struct {
+		name      string
+		prefix    string
+		candidate string
+
+		wantProbability float32
+	}{
+		{
+			name:            "ok - seen candidate",
+			prefix:          "a",
+			candidate:       "b",
+			wantProbability: 2.0 / 6,
+		},
+		{
+			name:            "ok - unseen candidate",
+			prefix:          "a",
+			candidate:       "a",
+			wantProbability: 1.0 / 6,
+		},
+		{
+			name:            "ok - unknown candidate",
+			prefix:          "a",
+			candidate:       "z",
+			wantProbability: 1.0 / 6,
+		},
+		{
+			name:            "ok - unseen prefix",
+			prefix:          "z",
+			candidate:       "a",
+			wantProbability: 1.0 / 4,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var probability, err = chain.CandidateProbability(tt.prefix, tt.candidate)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if probability != tt.wantProbability {
+				t.Errorf("got %v, want %v", probability, tt.wantProbability)
+			}
+		})
+	}
+}
+
+func TestSmoothing_ScoreText(t *testing.T) {
+	t.Parallel()
+
+	var plain, _ = NewNGramChain(3)
+	var smoothed, _ = NewNGramChain(3, WithSmoothing(KneserNey()))
+	plain.ProcessText(strings.NewReader(smoothingText))
+	smoothed.ProcessText(strings.NewReader(smoothingText))
+
+	var text = "I am the joker. It's a wonderful night."
+
+	var plainScore, _ = plain.ScoreText(strings.NewReader(text))
+	if !math.IsInf(plainScore.Perplexity, 1) {
+		t.Errorf("got %v, want %v", plainScore.Perplexity, math.Inf(1))
+	}
+
+	var smoothedScore, _ = smoothed.ScoreText(strings.NewReader(text))
+	if math.IsInf(smoothedScore.Perplexity, 0) || smoothedScore.Perplexity <= 1 {
+		t.Errorf("got %v, want a finite perplexity", smoothedScore.Perplexity)
+	}
+
+	// the chain keeps generating text from the seen ngrams only
+	if candidate := smoothed.GetCandidate("You are"); candidate != "" {
+		t.Errorf("got %v, want %v", candidate, "")
+	}
+}
+
+func TestSmoothing_invalidation(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2, WithSmoothing(Laplace()))
+	chain.ProcessText(strings.NewReader("a b"))
+
+	var before, _ = chain.CandidateProbability("a", "b")
+	chain.ProcessText(strings.NewReader("a c"))
+	var after, _ = chain.CandidateProbability("a", "b")
+
+	// the vocabulary grew and a has a new candidate
+	if before != 2.0/3 || after != 2.0/5 {
+		t.Errorf("got %v and %v, want %v and %v", before, after, 2.0/3, 2.0/5)
+	}
+}
+
+func Test_kneserNeyDiscounts(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name          string
+		countOfCounts [goodTuringLimit + 1]int
+
+		wantDiscounts [4]float64
+	}{
+		{
+			name:          "ok",
+			countOfCounts: [goodTuringLimit + 1]int{0, 10, 4, 2, 1, 0},
+			// Y = 10/18, D1 = 1 - 2Y 4/10, D2 = 2 - 3Y 2/4, D3+ = 3 - 4Y 1/2
+			wantDiscounts: [4]float64{0, 1 - 8.0/18, 2 - 15.0/18, 3 - 20.0/18},
+		},
+		{
+			name:          "ok - not enough counts",
+			countOfCounts: [goodTuringLimit + 1]int{},
+			wantDiscounts: [4]float64{0, defaultDiscount, defaultDiscount, defaultDiscount},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var discounts = kneserNeyDiscounts(tt.countOfCounts)
+			for i := range discounts {
+				if !almostEqual(discounts[i], tt.wantDiscounts[i]) {
+					t.Errorf("got %v, want %v", discounts, tt.wantDiscounts)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,674 @@
+package markov
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Candidate is a symbol which followed a prefix, identified by its ID in the
+// chain Vocabulary, and the number of times it did
+type Candidate struct {
+	ID        uint32
+	Frequency int
+}
+
+// Store holds the ngrams of a chain: every prefix, as the IDs of its symbols in
+// the chain Vocabulary, with the candidates which followed it. It's set with
+// WithStore and it's guarded by the chain, so it must not be used anywhere else
+// once the chain is created.
+//
+// NewMapStore, NewTrieStore and NewShardedStore keep the ngrams in memory,
+// OpenMappedStore reads them from a file written by SaveMapped and
+// OpenDiskStore keeps them in a file. The lower orders of chains with backoff
+// are always kept in memory.
+type Store interface {
+	// Increment adds frequency to the occurrences of the candidate after the
+	// prefix, adding any of them if needed. It returns true if the prefix is
+	// new
+	Increment(prefix []uint32, candidate uint32, frequency int) (bool, error)
+	// Candidates returns the candidates of the prefix, or false if it doesn't
+	// exist
+	Candidates(prefix []uint32) ([]Candidate, bool)
+	// Range calls f for every prefix and its candidates until it returns
+	// false. The order must be the same on every call
+	Range(f func(prefix []uint32, candidates []Candidate) bool)
+	// Len returns the number of prefixes
+	Len() int
+}
+
+// SymbolStore is a Store which also keeps the symbols of a chain of strings, so
+// a chain created with it knows its vocabulary. The chain adds every new symbol
+// in ID order, starting after the sequence boundaries.
+type SymbolStore interface {
+	Store
+	// Symbols returns the symbols in the order they were added
+	Symbols() []string
+	// AddSymbol adds a new symbol. Errors must be returned by the next call
+	// to Increment
+	AddSymbol(symbol string)
+}
+
+// ErrReadOnly is returned when processing input with a chain whose store can't
+// be written, like a MappedStore
+var ErrReadOnly = errors.New("read only store")
+
+// errStoreNotReplaceable is returned when loading a chain into one whose store
+// can't be recreated
+var errStoreNotReplaceable = errors.New("chain store can't be replaced")
+
+// ngramStore is the interface used by the chain to access its stores, keyed by
+// the packed IDs of the prefixes. The stores of this package implement it
+// directly, any other Store is wrapped by a storeAdapter. It's guarded by the
+// chain lock
+type ngramStore interface {
+	// get returns the candidates of the key, if it exists
+	get(key string) (*candidates, bool)
+	// increment adds frequency to the occurrences of the candidate after the
+	// key, adding the key if it doesn't exist yet, and returns whether it was
+	// added
+	increment(key string, candidate uint32, frequency int) (bool, error)
+	// len returns the number of keys in the store
+	len() int
+	// entry returns the i-th key of the store and its candidates, in the
+	// order of each
+	entry(i int) (string, *candidates)
+	// each calls f for every key and its candidates until it returns false
+	each(f func(key string, candidates *candidates) bool)
+	// empty returns a new empty store of the same kind, or nil if the store
+	// can't be recreated, like the ones backed by a file
+	empty() ngramStore
+}
+
+// NewMapStore returns a Store keeping the ngrams in a map. It's the fastest
+// store and the default one.
+func NewMapStore() Store {
+	return &mapStore{candidates: make(map[string]*candidates)}
+}
+
+// NewTrieStore returns a Store keeping the ngrams in a trie of symbol IDs, so
+// the prefixes sharing their first symbols share memory. See WithTrieStore.
+func NewTrieStore() Store {
+	// the root is the node of the empty key
+	return &trieStore{nodes: []trieNode{{}}, values: []*candidates{nil}}
+}
+
+// NewShardedStore returns a Store keeping the ngrams in several maps, selected
+// by the hash of the prefix, each with its own lock. Chains using it can then
+// process several texts at the same time, since the goroutines only wait for
+// each other when they add ngrams to the same map. It also keeps every map
+// smaller, so growing one doesn't rehash all the ngrams. A shards of 0 uses
+// several maps per CPU.
+func NewShardedStore(shards uint) Store {
+	if shards == 0 {
+		shards = shardsPerCPU * uint(runtime.GOMAXPROCS(0))
+	}
+
+	var store = &shardedStore{shards: make([]storeShard, shards)}
+	for i := range store.shards {
+		store.shards[i].candidates = make(map[string]*candidates)
+	}
+
+	return store
+}
+
+// shardsPerCPU is the number of shards per CPU of the sharded stores by
+// default, so goroutines adding ngrams at the same time rarely need the same
+// shard
+const shardsPerCPU = 8
+
+func newMapStore() ngramStore {
+	return NewMapStore().(*mapStore)
+}
+
+func newTrieStore() ngramStore {
+	return NewTrieStore().(*trieStore)
+}
+
+// asNgramStore returns the store as used by the chain, wrapping it if it's not
+// one of this package
+func asNgramStore(store Store) ngramStore {
+	if s, ok := store.(ngramStore); ok {
+		return s
+	}
+
+	return newStoreAdapter(store)
+}
+
+// openStore prepares a chain created with a store which could already have
+// ngrams: the symbols of a SymbolStore are interned with the IDs they had, and
+// new ones are added to it, and the seeds are rebuilt from the stored
+// prefixes. It fails if the prefixes don't have n-1 symbols or refer to
+// unknown ones
+func (c *Chain[T]) openStore(symbolStore SymbolStore) error {
+	if symbolStore != nil {
+		var table, ok = any(c.symbols).(*symbolTable[string])
+		if !ok {
+			return errors.New("symbol stores need a chain of strings")
+		}
+
+		for i, symbol := range symbolStore.Symbols() {
+			if id := table.intern(symbol); id != firstSymbolID+uint32(i) {
+				return fmt.Errorf("%w: symbol %q stored twice", ErrInvalidFormat, symbol)
+			}
+		}
+		table.onIntern = symbolStore.AddSymbol
+	}
+
+	var nextID = c.symbols.nextID()
+	var err error
+	c.store.each(func(key string, candidates *candidates) bool {
+		var prefix = unpackKey(key)
+		if len(prefix) != int(c.n)-1 {
+			err = fmt.Errorf("%w: store has prefixes of %d symbols, expected %d", ErrOrderMismatch, len(prefix), c.n-1)
+			return false
+		}
+
+		for _, id := range prefix {
+			if id >= nextID {
+				err = fmt.Errorf("%w: store has unknown symbol ID %d", ErrInvalidFormat, id)
+				return false
+			}
+		}
+
+		for _, wf := range candidates.words {
+			if wf.word >= nextID {
+				err = fmt.Errorf("%w: store has unknown symbol ID %d", ErrInvalidFormat, wf.word)
+				return false
+			}
+		}
+
+		if !c.bounded && c.seedPolicy != nil && c.seedPolicy(c.symbols.symbols(prefix)) {
+			c.seeds = append(c.seeds, key)
+		}
+
+		return true
+	})
+
+	if err == nil {
+		err = storeErr(c.store)
+	}
+
+	return err
+}
+
+// storeErr returns the error which stopped the store from reading its ngrams,
+// like the one of a DiskStore, so they're not mistaken for missing ones
+func storeErr(store ngramStore) error {
+	if s, ok := store.(interface{ Err() error }); ok {
+		return s.Err()
+	}
+
+	return nil
+}
+
+// isStriped returns true if the store locks its own stripes on every write
+func isStriped(store ngramStore) bool {
+	var _, striped = store.(*shardedStore)
+	return striped
+}
+
+// mapStore is a map from the packed keys to their candidates. It's the fastest
+// store, but every key keeps its own copy of all its IDs
+type mapStore struct {
+	candidates map[string]*candidates
+
+	// keys keeps the keys in insertion order, so random selections don't
+	// depend on the map iteration order and can be reproduced
+	keys []string
+}
+
+func (s *mapStore) get(key string) (*candidates, bool) {
+	var candidates, exists = s.candidates[key]
+	return candidates, exists
+}
+
+func (s *mapStore) increment(key string, candidate uint32, frequency int) (bool, error) {
+	var values, exists = s.candidates[key]
+	if !exists {
+		values = &candidates{}
+		s.candidates[key] = values
+		s.keys = append(s.keys, key)
+	}
+
+	values.addCandidate(candidate, frequency)
+	return !exists, nil
+}
+
+func (s *mapStore) len() int {
+	return len(s.keys)
+}
+
+func (s *mapStore) entry(i int) (string, *candidates) {
+	var key = s.keys[i]
+	return key, s.candidates[key]
+}
+
+func (s *mapStore) each(f func(key string, candidates *candidates) bool) {
+	for _, key := range s.keys {
+		if !f(key, s.candidates[key]) {
+			return
+		}
+	}
+}
+
+func (s *mapStore) empty() ngramStore {
+	return newMapStore()
+}
+
+// Increment implements Store
+func (s *mapStore) Increment(prefix []uint32, candidate uint32, frequency int) (bool, error) {
+	return s.increment(packKey(prefix), candidate, frequency)
+}
+
+// Candidates implements Store
+func (s *mapStore) Candidates(prefix []uint32) ([]Candidate, bool) {
+	return storeCandidates(s, prefix)
+}
+
+// Range implements Store
+func (s *mapStore) Range(f func(prefix []uint32, candidates []Candidate) bool) {
+	rangeStore(s, f)
+}
+
+// Len implements Store
+func (s *mapStore) Len() int {
+	return s.len()
+}
+
+// shardedStore splits the keys in several maps by their hash. The shards are
+// walked one after the other, every one in insertion order. Every shard has
+// its own lock, so the keys can be incremented concurrently while the chain is
+// only locked for reading or shared by the writers, see chainLock
+type shardedStore struct {
+	shards []storeShard
+}
+
+// storeShard is a map of a shardedStore and the lock guarding its writes
+type storeShard struct {
+	lock       sync.Mutex
+	candidates map[string]*candidates
+	keys       []string
+
+	// the padding keeps every shard lock in its own cache line, so goroutines
+	// using different shards don't slow each other down
+	_ [64]byte
+}
+
+// shard returns the shard holding the key
+func (s *shardedStore) shard(key string) *storeShard {
+	// FNV-1a, inlined so lookups don't allocate
+	var hash uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return &s.shards[hash%uint32(len(s.shards))]
+}
+
+func (s *shardedStore) get(key string) (*candidates, bool) {
+	var candidates, exists = s.shard(key).candidates[key]
+	return candidates, exists
+}
+
+func (s *shardedStore) increment(key string, candidate uint32, frequency int) (bool, error) {
+	var shard = s.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	var values, exists = shard.candidates[key]
+	if !exists {
+		values = &candidates{}
+		shard.candidates[key] = values
+		shard.keys = append(shard.keys, key)
+	}
+
+	values.addCandidate(candidate, frequency)
+	return !exists, nil
+}
+
+func (s *shardedStore) len() int {
+	var total = 0
+	for i := range s.shards {
+		total += len(s.shards[i].keys)
+	}
+
+	return total
+}
+
+func (s *shardedStore) entry(i int) (string, *candidates) {
+	for j := range s.shards {
+		var shard = &s.shards[j]
+		if i < len(shard.keys) {
+			var key = shard.keys[i]
+			return key, shard.candidates[key]
+		}
+		i -= len(shard.keys)
+	}
+
+	panic("markov: store entry out of range")
+}
+
+func (s *shardedStore) each(f func(key string, candidates *candidates) bool) {
+	for i := range s.shards {
+		var shard = &s.shards[i]
+		for _, key := range shard.keys {
+			if !f(key, shard.candidates[key]) {
+				return
+			}
+		}
+	}
+}
+
+func (s *shardedStore) empty() ngramStore {
+	return NewShardedStore(uint(len(s.shards))).(*shardedStore)
+}
+
+// Increment implements Store
+func (s *shardedStore) Increment(prefix []uint32, candidate uint32, frequency int) (bool, error) {
+	return s.increment(packKey(prefix), candidate, frequency)
+}
+
+// Candidates implements Store
+func (s *shardedStore) Candidates(prefix []uint32) ([]Candidate, bool) {
+	return storeCandidates(s, prefix)
+}
+
+// Range implements Store
+func (s *shardedStore) Range(f func(prefix []uint32, candidates []Candidate) bool) {
+	rangeStore(s, f)
+}
+
+// Len implements Store
+func (s *shardedStore) Len() int {
+	return s.len()
+}
+
+// storeAdapter makes any Store usable by the chain. It keeps the keys of the
+// store in order, so random selections don't walk the whole store
+type storeAdapter struct {
+	store Store
+	keys  []string
+}
+
+func newStoreAdapter(store Store) *storeAdapter {
+	var adapter = &storeAdapter{store: store, keys: make([]string, 0, store.Len())}
+	store.Range(func(prefix []uint32, _ []Candidate) bool {
+		adapter.keys = append(adapter.keys, packKey(prefix))
+		return true
+	})
+
+	return adapter
+}
+
+func (s *storeAdapter) get(key string) (*candidates, bool) {
+	var list, exists = s.store.Candidates(unpackKey(key))
+	if !exists {
+		return nil, false
+	}
+
+	return newCandidates(list), true
+}
+
+func (s *storeAdapter) increment(key string, candidate uint32, frequency int) (bool, error) {
+	var created, err = s.store.Increment(unpackKey(key), candidate, frequency)
+	if created {
+		s.keys = append(s.keys, key)
+	}
+
+	return created, err
+}
+
+func (s *storeAdapter) len() int {
+	return len(s.keys)
+}
+
+func (s *storeAdapter) entry(i int) (string, *candidates) {
+	var key = s.keys[i]
+	var candidates, _ = s.get(key)
+	return key, candidates
+}
+
+func (s *storeAdapter) each(f func(key string, candidates *candidates) bool) {
+	s.store.Range(func(prefix []uint32, list []Candidate) bool {
+		return f(packKey(prefix), newCandidates(list))
+	})
+}
+
+func (s *storeAdapter) empty() ngramStore {
+	return nil
+}
+
+// storeCandidates returns the candidates of the prefix as exported by Store
+func storeCandidates(s ngramStore, prefix []uint32) ([]Candidate, bool) {
+	var candidates, exists = s.get(packKey(prefix))
+	if !exists {
+		return nil, false
+	}
+
+	return candidates.list(), true
+}
+
+// rangeStore walks the store as described by Store.Range
+func rangeStore(s ngramStore, f func(prefix []uint32, candidates []Candidate) bool) {
+	s.each(func(key string, candidates *candidates) bool {
+		return f(unpackKey(key), candidates.list())
+	})
+}
+
+// newCandidates returns the candidates with the given frequencies
+func newCandidates(list []Candidate) *candidates {
+	var candidates = &candidates{}
+	for _, candidate := range list {
+		candidates.addCandidate(candidate.ID, candidate.Frequency)
+	}
+
+	return candidates
+}
+
+// list returns the candidates as exported by Store
+func (c *candidates) list() []Candidate {
+	var list = make([]Candidate, len(c.words))
+	for i, wf := range c.words {
+		list[i] = Candidate{ID: wf.word, Frequency: wf.frequency}
+	}
+
+	return list
+}
+
+// trieStore is a trie of symbol IDs, where every node adds one ID to the key
+// of its parent, so the keys sharing their first symbols share their nodes.
+// The nodes are kept in flat lists indexed by their position, the root being
+// the first one, and the keys are rebuilt by walking up from their last node.
+// It takes less memory than the map, at the cost of walking the trie on every
+// lookup
+type trieStore struct {
+	// nodes holds the ID and the parent of every node, and values the
+	// candidates of the key ending on it, if any
+	nodes  []trieNode
+	values []*candidates
+
+	// keys holds the last node of every key added, in insertion order
+	keys []uint32
+
+	// children finds the nodes by their parent and ID. It's an open
+	// addressing hash table of node positions, where 0 is an empty slot since
+	// the root is nobody's child
+	children []uint32
+}
+
+// trieNode is a node of a trieStore: the last ID of its key and the node
+// holding the rest
+type trieNode struct {
+	id     uint32
+	parent uint32
+}
+
+// trieLoadFactor is the number of quarters of the trie hash table which can be
+// used before it's grown
+const trieLoadFactor = 3
+
+func (s *trieStore) get(key string) (*candidates, bool) {
+	var node, found = s.find(key)
+	if !found || s.values[node] == nil {
+		return nil, false
+	}
+
+	return s.values[node], true
+}
+
+func (s *trieStore) increment(key string, candidate uint32, frequency int) (bool, error) {
+	var node uint32
+	for i := 0; i < len(key); i += idSize {
+		var id = keyID(key, i)
+		if child := s.child(node, id); child != 0 {
+			node = child
+		} else {
+			node = s.addChild(node, id)
+		}
+	}
+
+	var values, exists = s.values[node], s.values[node] != nil
+	if !exists {
+		values = &candidates{}
+		s.values[node] = values
+		s.keys = append(s.keys, node)
+	}
+
+	values.addCandidate(candidate, frequency)
+	return !exists, nil
+}
+
+func (s *trieStore) len() int {
+	return len(s.keys)
+}
+
+func (s *trieStore) entry(i int) (string, *candidates) {
+	var node = s.keys[i]
+	return s.key(node), s.values[node]
+}
+
+func (s *trieStore) each(f func(key string, candidates *candidates) bool) {
+	for _, node := range s.keys {
+		if !f(s.key(node), s.values[node]) {
+			return
+		}
+	}
+}
+
+func (s *trieStore) empty() ngramStore {
+	return newTrieStore()
+}
+
+// Increment implements Store
+func (s *trieStore) Increment(prefix []uint32, candidate uint32, frequency int) (bool, error) {
+	return s.increment(packKey(prefix), candidate, frequency)
+}
+
+// Candidates implements Store
+func (s *trieStore) Candidates(prefix []uint32) ([]Candidate, bool) {
+	return storeCandidates(s, prefix)
+}
+
+// Range implements Store
+func (s *trieStore) Range(f func(prefix []uint32, candidates []Candidate) bool) {
+	rangeStore(s, f)
+}
+
+// Len implements Store
+func (s *trieStore) Len() int {
+	return s.len()
+}
+
+// find returns the node of the key, and false if the trie doesn't go that far
+func (s *trieStore) find(key string) (uint32, bool) {
+	var node uint32
+	for i := 0; i < len(key); i += idSize {
+		if node = s.child(node, keyID(key, i)); node == 0 {
+			return 0, false
+		}
+	}
+
+	return node, true
+}
+
+// key returns the packed key ending on the node, built in a single allocation
+func (s *trieStore) key(node uint32) string {
+	var size = 0
+	for n := node; n != 0; n = s.nodes[n].parent {
+		size += idSize
+	}
+
+	var sb strings.Builder
+	sb.Grow(size)
+	s.writeKey(&sb, node)
+
+	return sb.String()
+}
+
+// writeKey writes the IDs from the root to the node, walking up first
+func (s *trieStore) writeKey(sb *strings.Builder, node uint32) {
+	if node == 0 {
+		return
+	}
+	s.writeKey(sb, s.nodes[node].parent)
+
+	var buf [idSize]byte
+	binary.LittleEndian.PutUint32(buf[:], s.nodes[node].id)
+	sb.Write(buf[:])
+}
+
+// child returns the node with the given parent and ID, or 0 if there's none
+func (s *trieStore) child(parent, id uint32) uint32 {
+	if len(s.children) == 0 {
+		return 0
+	}
+
+	var mask = uint32(len(s.children) - 1)
+	for slot := trieHash(parent, id) & mask; ; slot = (slot + 1) & mask {
+		var node = s.children[slot]
+		if node == 0 || (s.nodes[node].id == id && s.nodes[node].parent == parent) {
+			return node
+		}
+	}
+}
+
+// addChild adds a node with the given parent and ID, and returns it. It must
+// not exist yet
+func (s *trieStore) addChild(parent, id uint32) uint32 {
+	var node = uint32(len(s.nodes))
+	s.nodes = append(s.nodes, trieNode{id: id, parent: parent})
+	s.values = append(s.values, nil)
+
+	// the table grows before it gets too full for the probes to be short
+	if 4*(len(s.nodes)-1) > trieLoadFactor*len(s.children) {
+		s.children = make([]uint32, max(16, 2*len(s.children)))
+		for n := uint32(1); n < node; n++ {
+			s.place(n)
+		}
+	}
+	s.place(node)
+
+	return node
+}
+
+// place puts the node in the first free slot of the hash table from its hash
+func (s *trieStore) place(node uint32) {
+	var mask = uint32(len(s.children) - 1)
+	var slot = trieHash(s.nodes[node].parent, s.nodes[node].id) & mask
+	for s.children[slot] != 0 {
+		slot = (slot + 1) & mask
+	}
+
+	s.children[slot] = node
+}
+
+// trieHash mixes the parent and the ID of a node, so consecutive IDs are
+// spread over the hash table
+func trieHash(parent, id uint32) uint32 {
+	return uint32((uint64(parent)<<32 | uint64(id)) * 0x9e3779b97f4a7c15 >> 32)
+}
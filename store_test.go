@@ -0,0 +1,373 @@
+package markov
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_ngramStore(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name     string
+		newStore func() ngramStore
+		// unordered stores don't walk their entries in insertion order
+		unordered bool
+	}{
+		{name: "map", newStore: newMapStore},
+		{name: "trie", newStore: newTrieStore},
+		{name: "sharded", newStore: func() ngramStore { return NewShardedStore(3).(ngramStore) }, unordered: true},
+		{name: "adapter", newStore: func() ngramStore { return newStoreAdapter(NewMapStore()) }},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var store = tt.newStore()
+			var keys = []string{
+				packKey([]uint32{2, 3}),
+				packKey([]uint32{2, 4}),
+				packKey([]uint32{2}),
+				packKey([]uint32{}),
+				packKey([]uint32{3, 2}),
+			}
+
+			for i, key := range keys {
+				var created, err = store.increment(key, uint32(i), 1)
+				if err != nil || !created {
+					t.Fatalf("key %v: got %v %v, want %v", unpackKey(key), created, err, true)
+				}
+			}
+
+			if created, _ := store.increment(keys[0], 0, 2); created {
+				t.Errorf("got %v, want %v", created, false)
+			}
+
+			if store.len() != len(keys) {
+				t.Errorf("got %v, want %v", store.len(), len(keys))
+			}
+
+			// entries are walked in the same order by entry and each
+			var entries []string
+			for i := range keys {
+				var key, _ = store.entry(i)
+				entries = append(entries, key)
+			}
+
+			var walked []string
+			store.each(func(key string, _ *candidates) bool {
+				walked = append(walked, key)
+				return true
+			})
+			if !reflect.DeepEqual(walked, entries) {
+				t.Errorf("got %v, want %v", walked, entries)
+			}
+
+			// ordered stores walk them in insertion order
+			var wantEntries = keys
+			if tt.unordered {
+				entries = slices.Clone(entries)
+				wantEntries = slices.Clone(keys)
+				sort.Strings(entries)
+				sort.Strings(wantEntries)
+			}
+			if !reflect.DeepEqual(entries, wantEntries) {
+				t.Errorf("got %v, want %v", entries, wantEntries)
+			}
+
+			var stopped = 0
+			store.each(func(string, *candidates) bool {
+				stopped++
+				return stopped < 2
+			})
+			if stopped != 2 {
+				t.Errorf("got %v, want %v", stopped, 2)
+			}
+
+			if candidates, _ := store.get(keys[0]); candidates.words[0].frequency != 3 {
+				t.Errorf("got %v, want %v", candidates.words[0].frequency, 3)
+			}
+
+			for _, key := range keys {
+				if _, exists := store.get(key); !exists {
+					t.Errorf("key %v: got %v, want %v", unpackKey(key), exists, true)
+				}
+			}
+
+			// prefixes of other keys only exist if they were added
+			for _, key := range []string{packKey([]uint32{3}), packKey([]uint32{2, 5}), packKey([]uint32{2, 3, 4})} {
+				if _, exists := store.get(key); exists {
+					t.Errorf("key %v: got %v, want %v", unpackKey(key), exists, false)
+				}
+			}
+		})
+	}
+}
+
+func Test_trieStore_sharedNodes(t *testing.T) {
+	t.Parallel()
+
+	var store = newTrieStore().(*trieStore)
+	for i := uint32(0); i < 100; i++ {
+		store.increment(packKey([]uint32{2, 3, i}), i, 1)
+	}
+
+	// the keys share the nodes of their first two IDs, after the root
+	if len(store.nodes) != 103 {
+		t.Errorf("got %v, want %v", len(store.nodes), 103)
+	}
+
+	if key, _ := store.entry(42); key != packKey([]uint32{2, 3, 42}) {
+		t.Errorf("got %v, want %v", unpackKey(key), []uint32{2, 3, 42})
+	}
+}
+
+func TestWithTrieStore(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		opts []Option
+	}{
+		{name: "default"},
+		{name: "sentence boundaries", opts: []Option{WithSentenceBoundaries()}},
+		{name: "backoff", opts: []Option{WithBackoff(KatzBackoff())}},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mapChain, _ = NewNGramChain(3, append(tt.opts, WithSeed(1))...)
+			var trieChain, _ = NewNGramChain(3, append(tt.opts, WithSeed(1), WithTrieStore())...)
+			for _, chain := range []*NGramChain{mapChain, trieChain} {
+				chain.ProcessText(strings.NewReader(smoothingText))
+			}
+
+			if entries := storeEntries(trieChain.chain); !reflect.DeepEqual(entries, storeEntries(mapChain.chain)) {
+				t.Errorf("got %v, want %v", entries, storeEntries(mapChain.chain))
+			}
+
+			if text := trieChain.GenerateRandomText(20); text != mapChain.GenerateRandomText(20) {
+				t.Errorf("got %q, want the same text as the map store", text)
+			}
+
+			var mapBuf, trieBuf bytes.Buffer
+			mapChain.Save(&mapBuf)
+			trieChain.Save(&trieBuf)
+			if !bytes.Equal(trieBuf.Bytes(), mapBuf.Bytes()) {
+				t.Errorf("got a different encoding than the map store")
+			}
+
+			// loaded chains keep their store
+			var loaded, _ = NewNGramChain(3, append(tt.opts, WithTrieStore())...)
+			if err := loaded.Load(&trieBuf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, ok := loaded.chain.store.(*trieStore); !ok {
+				t.Errorf("got %T, want %T", loaded.chain.store, &trieStore{})
+			}
+
+			if entries := sortedEntries(loaded.chain); !reflect.DeepEqual(entries, sortedEntries(mapChain.chain)) {
+				t.Errorf("got %v, want %v", entries, sortedEntries(mapChain.chain))
+			}
+		})
+	}
+}
+
+// wrappedStore hides the internals of the store it wraps, like a Store
+// implemented outside of the package
+type wrappedStore struct {
+	Store
+}
+
+func TestWithStore(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name  string
+		store func() Store
+		// unordered stores walk their prefixes in a different order, so they
+		// generate different texts
+		unordered bool
+	}{
+		{name: "map", store: NewMapStore},
+		{name: "trie", store: NewTrieStore},
+		{name: "sharded", store: func() Store { return NewShardedStore(4) }, unordered: true},
+		{name: "external", store: func() Store { return wrappedStore{NewMapStore()} }},
+	}
+
+	var want, _ = NewNGramChain(3, WithBackoff(KatzBackoff()), WithSeed(1))
+	want.ProcessText(strings.NewReader(smoothingText))
+	var wantText = want.GenerateRandomText(20)
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, err = NewNGramChain(3, WithBackoff(KatzBackoff()), WithSeed(1), WithStore(tt.store()))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := chain.ProcessText(strings.NewReader(smoothingText)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if entries := sortedEntries(chain.chain); !reflect.DeepEqual(entries, sortedEntries(want.chain)) {
+				t.Errorf("got %v, want %v", entries, sortedEntries(want.chain))
+			}
+
+			if entries := lowerEntries(chain.chain); !reflect.DeepEqual(entries, lowerEntries(want.chain)) {
+				t.Errorf("got %v, want %v", entries, lowerEntries(want.chain))
+			}
+
+			if text := chain.GenerateRandomText(20); !tt.unordered && text != wantText {
+				t.Errorf("got %q, want %q", text, wantText)
+			}
+		})
+	}
+}
+
+func TestWithStore_errors(t *testing.T) {
+	t.Parallel()
+
+	var storeWith = func(prefix ...uint32) Store {
+		var store = NewMapStore()
+		store.Increment(prefix, firstSymbolID, 1)
+		return store
+	}
+
+	var tests = []struct {
+		name  string
+		store Store
+
+		wantErr error
+	}{
+		{
+			name:    "error - prefixes of another order",
+			store:   storeWith(firstSymbolID),
+			wantErr: ErrOrderMismatch,
+		},
+		{
+			name:    "error - unknown symbols",
+			store:   storeWith(firstSymbolID, firstSymbolID+1),
+			wantErr: ErrInvalidFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var _, err = NewNGramChain(3, WithStore(tt.store))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// benchmarkCorpusTokens is the number of tokens of the benchmark corpus
+const benchmarkCorpusTokens = 2_000_000
+
+var (
+	benchmarkCorpusOnce sync.Once
+	benchmarkCorpus     string
+)
+
+// getBenchmarkCorpus returns a text of benchmarkCorpusTokens words drawn from
+// a 50k words vocabulary with a Zipf distribution, like natural language
+func getBenchmarkCorpus() string {
+	benchmarkCorpusOnce.Do(func() {
+		var r = rand.New(rand.NewSource(1))
+		var zipf = rand.NewZipf(r, 1.1, 1, 50_000)
+
+		var sb strings.Builder
+		for i := 0; i < benchmarkCorpusTokens; i++ {
+			fmt.Fprintf(&sb, "w%d ", zipf.Uint64())
+		}
+		benchmarkCorpus = sb.String()
+	})
+
+	return benchmarkCorpus
+}
+
+func BenchmarkStore_memory(b *testing.B) {
+	var corpus = getBenchmarkCorpus()
+
+	for _, n := range []uint{3, 5} {
+		for _, store := range []struct {
+			name string
+			opts []Option
+		}{
+			{name: "map"},
+			{name: "trie", opts: []Option{WithTrieStore()}},
+		} {
+			b.Run(fmt.Sprintf("n=%d/%s", n, store.name), func(b *testing.B) {
+				var heap float64
+				var prefixes int
+
+				for i := 0; i < b.N; i++ {
+					var before, after runtime.MemStats
+					runtime.GC()
+					runtime.ReadMemStats(&before)
+
+					var chain, _ = NewNGramChain(n, store.opts...)
+					chain.ProcessText(strings.NewReader(corpus))
+
+					runtime.GC()
+					runtime.ReadMemStats(&after)
+
+					heap = float64(after.HeapAlloc) - float64(before.HeapAlloc)
+					prefixes = chain.chain.store.len()
+					runtime.KeepAlive(chain)
+				}
+
+				b.ReportMetric(heap/(1<<20), "heap-MB")
+				b.ReportMetric(heap/float64(prefixes), "B/prefix")
+			})
+		}
+	}
+}
+
+func BenchmarkStore_get(b *testing.B) {
+	var corpus = getBenchmarkCorpus()
+
+	for _, store := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "map"},
+		{name: "trie", opts: []Option{WithTrieStore()}},
+	} {
+		var chain, _ = NewNGramChain(3, store.opts...)
+		chain.ProcessText(strings.NewReader(corpus))
+
+		var keys = make([]string, 1024)
+		for i := range keys {
+			keys[i], _ = chain.chain.store.entry(i * chain.chain.store.len() / len(keys))
+		}
+
+		b.Run(store.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				chain.chain.store.get(keys[i%len(keys)])
+			}
+		})
+	}
+}
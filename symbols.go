@@ -0,0 +1,225 @@
+package markov
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// reserved symbol IDs for the boundaries of the sequences of bounded chains
+const (
+	startID uint32 = iota
+	endID
+
+	// firstSymbolID is the ID assigned to the first interned symbol
+	firstSymbolID
+)
+
+// noID is never assigned to any symbol, so it can stand for the symbols a
+// chain has never seen
+const noID uint32 = math.MaxUint32
+
+// idSize is the number of bytes used by every ID in a packed key
+const idSize = 4
+
+// symbolTable interns the symbols processed by a chain, mapping them to
+// consecutive uint32 IDs so they can be packed into compact store keys. It's
+// safe for concurrent use.
+type symbolTable[T comparable] struct {
+	ids    map[T]uint32
+	values []T
+	lock   *sync.RWMutex
+
+	// onIntern is called with every new symbol, while holding the lock, so a
+	// SymbolStore can keep them
+	onIntern func(symbol T)
+}
+
+func newSymbolTable[T comparable]() *symbolTable[T] {
+	return &symbolTable[T]{
+		ids: make(map[T]uint32),
+		// the reserved IDs have no symbol unless one is aliased to them
+		values: make([]T, firstSymbolID),
+		lock:   &sync.RWMutex{},
+	}
+}
+
+// intern returns the ID of the symbol, assigning a new one if it's not known
+// yet
+func (s *symbolTable[T]) intern(symbol T) uint32 {
+	if id, exists := s.id(symbol); exists {
+		return id
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the symbol could have been interned while we were waiting for the lock
+	if id, exists := s.ids[symbol]; exists {
+		return id
+	}
+
+	var id = uint32(len(s.values))
+	s.ids[symbol] = id
+	s.values = append(s.values, symbol)
+
+	if s.onIntern != nil {
+		s.onIntern(symbol)
+	}
+
+	return id
+}
+
+// id returns the ID of the symbol, if it's known
+func (s *symbolTable[T]) id(symbol T) (uint32, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var id, exists = s.ids[symbol]
+	return id, exists
+}
+
+// value returns the symbol with the given ID
+func (s *symbolTable[T]) value(id uint32) T {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.values[id]
+}
+
+// symbol returns the symbol with the given ID, or false if no symbol has it,
+// like the reserved IDs without an alias
+func (s *symbolTable[T]) symbol(id uint32) (T, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var zero T
+	if int64(id) >= int64(len(s.values)) {
+		return zero, false
+	}
+
+	var symbol = s.values[id]
+	if existing, exists := s.ids[symbol]; !exists || existing != id {
+		return zero, false
+	}
+
+	return symbol, true
+}
+
+// len returns the number of symbols in the table
+func (s *symbolTable[T]) len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.ids)
+}
+
+// all returns every symbol in the table, sorted by ID
+func (s *symbolTable[T]) all() []T {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var symbols = make([]T, 0, len(s.ids))
+	for id, symbol := range s.values {
+		if existing, exists := s.ids[symbol]; exists && existing == uint32(id) {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	return symbols
+}
+
+// interned returns the symbols interned so far, sorted by ID, without the
+// reserved IDs
+func (s *symbolTable[T]) interned() []T {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]T(nil), s.values[firstSymbolID:]...)
+}
+
+// nextID returns the ID the next interned symbol will get, so every ID below it
+// is either reserved or assigned
+func (s *symbolTable[T]) nextID() uint32 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return uint32(len(s.values))
+}
+
+// lookup returns the IDs of the given symbols. Unknown symbols get noID, which
+// never matches any key
+func (s *symbolTable[T]) lookup(symbols []T) []uint32 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var ids = make([]uint32, len(symbols))
+	for i, symbol := range symbols {
+		var id, exists = s.ids[symbol]
+		if !exists {
+			id = noID
+		}
+		ids[i] = id
+	}
+
+	return ids
+}
+
+// symbols returns the symbols with the given IDs
+func (s *symbolTable[T]) symbols(ids []uint32) []T {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var symbols = make([]T, len(ids))
+	for i, id := range ids {
+		symbols[i] = s.values[id]
+	}
+
+	return symbols
+}
+
+// alias maps the symbol to one of the reserved IDs, so it's interned as a
+// sequence boundary
+func (s *symbolTable[T]) alias(symbol T, id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.ids[symbol] = id
+	s.values[id] = symbol
+}
+
+// replace swaps the content of the table with the one of other
+func (s *symbolTable[T]) replace(other *symbolTable[T]) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.ids = other.ids
+	s.values = other.values
+}
+
+// packKey builds the store key for the given IDs. Every ID takes a fixed
+// number of bytes, so different sequences always produce different keys
+func packKey(ids []uint32) string {
+	var buf = make([]byte, idSize*len(ids))
+	for i, id := range ids {
+		binary.LittleEndian.PutUint32(buf[idSize*i:], id)
+	}
+
+	return string(buf)
+}
+
+// unpackKey returns the IDs of the given store key
+func unpackKey(key string) []uint32 {
+	var ids = make([]uint32, len(key)/idSize)
+	for i := range ids {
+		ids[i] = keyID(key, idSize*i)
+	}
+
+	return ids
+}
+
+// keyID returns the ID packed at the given offset of the store key, without
+// copying the key
+func keyID(key string, offset int) uint32 {
+	return uint32(key[offset]) | uint32(key[offset+1])<<8 | uint32(key[offset+2])<<16 | uint32(key[offset+3])<<24
+}
@@ -0,0 +1,226 @@
+package markov
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer splits the text processed by a chain into tokens. Split follows
+// the bufio.SplitFunc contract, so any split function can be used via
+// TokenizerFunc.
+type Tokenizer interface {
+	Split(data []byte, atEOF bool) (advance int, token []byte, err error)
+}
+
+// Detokenizer joins the tokens generated by a chain back into text. It should
+// match the Tokenizer used to process the input.
+type Detokenizer interface {
+	Join(tokens []string) string
+}
+
+// TokenizerFunc adapts a bufio.SplitFunc to the Tokenizer interface
+type TokenizerFunc bufio.SplitFunc
+
+// Split calls f(data, atEOF)
+func (f TokenizerFunc) Split(data []byte, atEOF bool) (int, []byte, error) {
+	return f(data, atEOF)
+}
+
+// DetokenizerFunc adapts a function to the Detokenizer interface
+type DetokenizerFunc func(tokens []string) string
+
+// Join calls f(tokens)
+func (f DetokenizerFunc) Join(tokens []string) string {
+	return f(tokens)
+}
+
+// WordTokenizer splits the text in space separated words, keeping any
+// punctuation attached to them, and joins them back with a single space. It's
+// the default tokenizer of a chain.
+type WordTokenizer struct{}
+
+// Split implements Tokenizer
+func (WordTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	return bufio.ScanWords(data, atEOF)
+}
+
+// Join implements Detokenizer
+func (WordTokenizer) Join(tokens []string) string {
+	return strings.Join(tokens, " ")
+}
+
+// RuneTokenizer splits the text in single runes, including spaces, for
+// character level chains. Tokens are joined back without separator.
+type RuneTokenizer struct{}
+
+// Split implements Tokenizer
+func (RuneTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	return bufio.ScanRunes(data, atEOF)
+}
+
+// Join implements Detokenizer
+func (RuneTokenizer) Join(tokens []string) string {
+	return strings.Join(tokens, "")
+}
+
+// PunctuationTokenizer splits the text in space separated words, emitting
+// punctuation as tokens of its own so "batman." becomes "batman" and ".".
+// Punctuation between letters or digits, like in "It's" or "3.14", is kept as
+// part of the word.
+//
+// When joining, punctuation is attached to the previous token, except for
+// opening punctuation like "(" which is attached to the next one.
+type PunctuationTokenizer struct{}
+
+// Split implements Tokenizer
+func (PunctuationTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	// skip leading spaces
+	var start = 0
+	for width := 0; start < len(data); start += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+	}
+
+	if start == len(data) {
+		// request more data
+		return start, nil, nil
+	}
+
+	// punctuation at the start of a token is a token on its own
+	var r, width = utf8.DecodeRune(data[start:])
+	if unicode.IsPunct(r) {
+		return start + width, data[start : start+width], nil
+	}
+
+	// scan until the end of the word, which is a space or a punctuation not
+	// followed by a letter or a digit
+	for i := start + width; i < len(data); i += width {
+		r, width = utf8.DecodeRune(data[i:])
+		if unicode.IsSpace(r) {
+			return i + width, data[start:i], nil
+		}
+
+		if !unicode.IsPunct(r) {
+			continue
+		}
+
+		var next = i + width
+		if next == len(data) {
+			if !atEOF {
+				// we need the next rune to know if the word ends here
+				return start, nil, nil
+			}
+			return i, data[start:i], nil
+		}
+
+		if nextRune, _ := utf8.DecodeRune(data[next:]); !unicode.IsLetter(nextRune) && !unicode.IsDigit(nextRune) {
+			return i, data[start:i], nil
+		}
+	}
+
+	// if we're at EOF, we have a final, non-empty word. Return it
+	if atEOF {
+		return len(data), data[start:], nil
+	}
+
+	// request more data
+	return start, nil, nil
+}
+
+// Join implements Detokenizer
+func (PunctuationTokenizer) Join(tokens []string) string {
+	var strBuilder strings.Builder
+
+	// there's no space before the first token
+	var attachNext = true
+	for _, token := range tokens {
+		var r, width = utf8.DecodeRuneInString(token)
+		var punctuation = width == len(token) && unicode.IsPunct(r)
+		var opening = punctuation && isOpeningPunctuation(r)
+
+		if !attachNext && (!punctuation || opening) {
+			strBuilder.WriteByte(' ')
+		}
+		strBuilder.WriteString(token)
+
+		attachNext = opening
+	}
+
+	return strBuilder.String()
+}
+
+// isOpeningPunctuation returns true for punctuation that precedes the text it
+// applies to, like brackets or opening quotes
+func isOpeningPunctuation(r rune) bool {
+	return unicode.In(r, unicode.Ps, unicode.Pi) || r == '¿' || r == '¡'
+}
+
+// RegexpTokenizer emits every match of Regexp on the text as a token, joining
+// them back with Separator. Since the text is streamed, a match must be found
+// at least every max token size bytes of the chain, see WithMaxTokenSize.
+type RegexpTokenizer struct {
+	Regexp    *regexp.Regexp
+	Separator string
+}
+
+// Split implements Tokenizer
+func (t RegexpTokenizer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	// keep looking while the matches are empty, since the scanner stops as soon
+	// as no token is returned at EOF
+	for start := 0; start <= len(data); {
+		var loc = t.Regexp.FindIndex(data[start:])
+		if loc == nil {
+			break
+		}
+
+		var from, to = start + loc[0], start + loc[1]
+
+		// the match might continue with the data that has not been read yet
+		if to == len(data) && !atEOF {
+			return 0, nil, nil
+		}
+
+		if from < to {
+			return to, data[from:to], nil
+		}
+
+		// skip the empty match, making sure we always advance
+		var _, width = utf8.DecodeRune(data[to:])
+		if width == 0 {
+			break
+		}
+		start = to + width
+	}
+
+	if atEOF {
+		// nothing left to match, discard the remaining data
+		return len(data), nil, nil
+	}
+
+	// request more data
+	return 0, nil, nil
+}
+
+// Join implements Detokenizer
+func (t RegexpTokenizer) Join(tokens []string) string {
+	return strings.Join(tokens, t.Separator)
+}
+
+// tokenize splits the text in tokens using the given tokenizer
+func tokenize(tokenizer Tokenizer, text string) []string {
+	var scanner = bufio.NewScanner(strings.NewReader(text))
+	scanner.Split(tokenizer.Split)
+
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+
+	return tokens
+}
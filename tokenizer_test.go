@@ -0,0 +1,273 @@
+package markov
+
+import (
+	"bufio"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTokenizers_Split(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		tokenizer Tokenizer
+		text      string
+
+		wantTokens []string
+	}{
+		{
+			name:       "word - punctuation attached",
+			tokenizer:  WordTokenizer{},
+			text:       " I am  batman.\nI am (groot)",
+			wantTokens: []string{"I", "am", "batman.", "I", "am", "(groot)"},
+		},
+		{
+			name:       "rune - spaces included",
+			tokenizer:  RuneTokenizer{},
+			text:       "añ b",
+			wantTokens: []string{"a", "ñ", " ", "b"},
+		},
+		{
+			name:       "punctuation - split from words",
+			tokenizer:  PunctuationTokenizer{},
+			text:       "I am batman. Am I (really)?!",
+			wantTokens: []string{"I", "am", "batman", ".", "Am", "I", "(", "really", ")", "?", "!"},
+		},
+		{
+			name:       "punctuation - inner punctuation kept",
+			tokenizer:  PunctuationTokenizer{},
+			text:       "It's 3.14, isn't it...",
+			wantTokens: []string{"It's", "3.14", ",", "isn't", "it", ".", ".", "."},
+		},
+		{
+			name:       "punctuation - trailing word",
+			tokenizer:  PunctuationTokenizer{},
+			text:       "  ¿qué tal  ",
+			wantTokens: []string{"¿", "qué", "tal"},
+		},
+		{
+			name:       "regexp - matches only",
+			tokenizer:  RegexpTokenizer{Regexp: regexp.MustCompile(`[a-z]+`)},
+			text:       "abc, DEF ghi-jkl",
+			wantTokens: []string{"abc", "ghi", "jkl"},
+		},
+		{
+			name:       "regexp - empty matches skipped",
+			tokenizer:  RegexpTokenizer{Regexp: regexp.MustCompile(`[0-9]*`)},
+			text:       "a12 b 3",
+			wantTokens: []string{"12", "3"},
+		},
+		{
+			name:       "func - custom split function",
+			tokenizer:  TokenizerFunc(bufio.ScanLines),
+			text:       "I am batman\nI am groot",
+			wantTokens: []string{"I am batman", "I am groot"},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var tokens = tokenize(tt.tokenizer, tt.text)
+			if !reflect.DeepEqual(tokens, tt.wantTokens) {
+				t.Errorf("got %q, want %q", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestTokenizers_SplitStreamed(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		tokenizer Tokenizer
+		text      string
+
+		wantTokens []string
+	}{
+		{
+			name:       "punctuation",
+			tokenizer:  PunctuationTokenizer{},
+			text:       "It's a trap! Isn't it?",
+			wantTokens: []string{"It's", "a", "trap", "!", "Isn't", "it", "?"},
+		},
+		{
+			name:       "regexp",
+			tokenizer:  RegexpTokenizer{Regexp: regexp.MustCompile(`\w+`)},
+			text:       "It's a trap! Isn't it?",
+			wantTokens: []string{"It", "s", "a", "trap", "Isn", "t", "it"},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// feed the scanner one byte at a time so tokens span several reads
+			var scanner = bufio.NewScanner(&oneByteReader{r: strings.NewReader(tt.text)})
+			scanner.Split(tt.tokenizer.Split)
+
+			var tokens []string
+			for scanner.Scan() {
+				tokens = append(tokens, scanner.Text())
+			}
+
+			if !reflect.DeepEqual(tokens, tt.wantTokens) {
+				t.Errorf("got %q, want %q", tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestDetokenizers_Join(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name        string
+		detokenizer Detokenizer
+		tokens      []string
+
+		wantText string
+	}{
+		{
+			name:        "word",
+			detokenizer: WordTokenizer{},
+			tokens:      []string{"I", "am", "batman."},
+			wantText:    "I am batman.",
+		},
+		{
+			name:        "rune",
+			detokenizer: RuneTokenizer{},
+			tokens:      []string{"a", "ñ", " ", "b"},
+			wantText:    "añ b",
+		},
+		{
+			name:        "punctuation",
+			detokenizer: PunctuationTokenizer{},
+			tokens:      []string{"¿", "I", "am", "(", "really", ")", "batman", "?", "!"},
+			wantText:    "¿I am (really) batman?!",
+		},
+		{
+			name:        "punctuation - leading punctuation",
+			detokenizer: PunctuationTokenizer{},
+			tokens:      []string{",", "and", "then"},
+			wantText:    ", and then",
+		},
+		{
+			name:        "regexp",
+			detokenizer: RegexpTokenizer{Separator: "-"},
+			tokens:      []string{"a", "b", "c"},
+			wantText:    "a-b-c",
+		},
+		{
+			name:        "func",
+			detokenizer: DetokenizerFunc(func(tokens []string) string { return strings.Join(tokens, "|") }),
+			tokens:      []string{"a", "b"},
+			wantText:    "a|b",
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var text = tt.detokenizer.Join(tt.tokens)
+			if text != tt.wantText {
+				t.Errorf("got %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestNGramChain_Tokenizer(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		opts []Option
+		text string
+
+		prefix        string
+		wantCandidate string
+		wantText      string
+	}{
+		{
+			name:          "default word tokenizer",
+			text:          "I am batman.",
+			prefix:        "I am",
+			wantCandidate: "batman.",
+			wantText:      "I am batman.",
+		},
+		{
+			name:          "punctuation tokenizer",
+			opts:          []Option{WithTokenizer(PunctuationTokenizer{})},
+			text:          "I am batman.",
+			prefix:        "am batman",
+			wantCandidate: ".",
+			wantText:      "I am batman.",
+		},
+		{
+			name:          "rune tokenizer",
+			opts:          []Option{WithTokenizer(RuneTokenizer{})},
+			text:          "I am",
+			prefix:        " a",
+			wantCandidate: "m",
+			wantText:      "I am.",
+		},
+		{
+			name: "custom detokenizer",
+			opts: []Option{
+				WithDetokenizer(DetokenizerFunc(func(tokens []string) string { return strings.Join(tokens, "_") })),
+				WithTokenizer(WordTokenizer{}),
+			},
+			text:          "I am batman.",
+			prefix:        "I am",
+			wantCandidate: "batman.",
+			wantText:      "I_am_batman.",
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, err = NewNGramChain(3, tt.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := chain.ProcessText(strings.NewReader(tt.text)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if candidate := chain.GetCandidate(tt.prefix); candidate != tt.wantCandidate {
+				t.Errorf("got %q, want %q", candidate, tt.wantCandidate)
+			}
+
+			if text := chain.GenerateRandomText(100); text != tt.wantText {
+				t.Errorf("got %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+// oneByteReader returns a single byte on every Read call
+type oneByteReader struct {
+	r *strings.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
@@ -0,0 +1,196 @@
+package markov
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"slices"
+)
+
+// ErrTrainerClosed is returned when writing to a Trainer after closing it
+var ErrTrainerClosed = errors.New("trainer is closed")
+
+// Trainer processes a text written to it in chunks, like ProcessText would if
+// the whole text was read at once: the tokens and ngrams spanning several
+// writes are kept. It implements io.Writer and io.ReaderFrom, so texts can be
+// piped into a chain with io.Copy. Flush ends the text, so the text written
+// afterwards is a different document. Every Trainer has its own window over
+// the text, so several of them can train the same chain at the same time, but
+// a Trainer is not safe for concurrent use.
+type Trainer struct {
+	chain    *NGramChain
+	window   *window[string]
+	splitter *tokenSplitter
+
+	// buf holds the end of the text written which hasn't been split in
+	// tokens yet, since the tokens might continue in the next write
+	buf []byte
+
+	// final is set once the tokenizer returned bufio.ErrFinalToken, so the
+	// rest of the document is ignored. err is the error which stopped the
+	// trainer, returned by every call from then on
+	final  bool
+	closed bool
+	err    error
+}
+
+// NewTrainer returns a Trainer processing the text written to it with the
+// chain
+func (c *NGramChain) NewTrainer() *Trainer {
+	return &Trainer{chain: c, window: c.chain.newWindow(), splitter: c.newSplitter()}
+}
+
+// Write implements io.Writer. It processes the tokens of p, keeping the end of
+// p for the next write if the last token might continue there. The ngrams of
+// chains with a sharded store are added in batches, and Flush adds the last
+// ones. It returns the number of bytes of p split in tokens before an error,
+// like a TextError wrapping bufio.ErrTooLong.
+func (t *Trainer) Write(p []byte) (int, error) {
+	if t.closed {
+		return 0, ErrTrainerClosed
+	}
+
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	var buffered = len(t.buf)
+	t.buf = append(t.buf, p...)
+
+	var split, err = t.split(false)
+	if err != nil {
+		return max(0, split-buffered), err
+	}
+
+	return len(p), nil
+}
+
+// readSize is the number of bytes a Trainer reads at once
+const readSize = 32 << 10
+
+// ReadFrom implements io.ReaderFrom, so io.Copy can write to the trainer
+// without copying the text. It processes the text read like Write, until r
+// returns io.EOF. Read errors are returned as a TextError, but they don't stop
+// the trainer. It returns the number of bytes read.
+func (t *Trainer) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	for {
+		if t.closed {
+			return read, ErrTrainerClosed
+		}
+
+		if t.err != nil {
+			return read, t.err
+		}
+
+		// read after the buffered text, so it doesn't need to be copied
+		t.buf = slices.Grow(t.buf, readSize)
+		var n, err = r.Read(t.buf[len(t.buf):cap(t.buf)])
+		t.buf = t.buf[:len(t.buf)+n]
+		read += int64(n)
+
+		if _, splitErr := t.split(false); splitErr != nil {
+			return read, splitErr
+		}
+
+		if err == io.EOF {
+			return read, nil
+		}
+
+		if err != nil {
+			return read, &TextError{Offset: t.splitter.offset, Err: err}
+		}
+	}
+}
+
+// Flush processes the text written so far as a whole document, ending the
+// last sequence of bounded chains. The text written afterwards is a new
+// document, so no ngram spans both.
+func (t *Trainer) Flush() error {
+	if t.closed {
+		return ErrTrainerClosed
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+
+	if _, err := t.split(true); err != nil {
+		return err
+	}
+
+	if err := t.window.close(); err != nil {
+		t.err = err
+		return err
+	}
+
+	t.window.reset()
+	t.buf = t.buf[:0]
+	t.final = false
+
+	return nil
+}
+
+// Close flushes the trainer, which can't be written to afterwards
+func (t *Trainer) Close() error {
+	if t.closed {
+		return nil
+	}
+
+	var err = t.Flush()
+	t.closed = true
+
+	return err
+}
+
+// split pushes the tokens of the buffered text to the window, keeping the
+// rest in the buffer. Unless the text is over, the last token is kept, since
+// it might continue in the next write. It returns the number of bytes split.
+func (t *Trainer) split(atEOF bool) (int, error) {
+	var split = 0
+	for !t.final && split < len(t.buf) {
+		// like a bufio.Scanner, the tokenizer never sees more data than the
+		// max token size
+		var data = t.buf[split:]
+		if len(data) > t.splitter.maxTokenSize {
+			data = data[:t.splitter.maxTokenSize]
+		}
+
+		var advance, token, err = t.splitter.splitToken(data, atEOF && len(data) == len(t.buf)-split)
+		if err == bufio.ErrFinalToken {
+			t.final = true
+			err = nil
+		}
+
+		if err != nil {
+			t.err = &TextError{Offset: t.splitter.offset, Err: err}
+			return split, t.err
+		}
+
+		if advance == 0 && token == nil {
+			if atEOF || len(data) < t.splitter.maxTokenSize {
+				break
+			}
+
+			// the data is as long as the max token size, so it must be split
+			t.err = &TextError{Offset: t.splitter.offset, Err: bufio.ErrTooLong}
+			return split, t.err
+		}
+		split += advance
+
+		if token != nil {
+			if err := t.chain.push(t.window, string(token)); err != nil {
+				t.err = err
+				return split, err
+			}
+		}
+	}
+
+	// the rest of the document is ignored after the final token
+	if t.final {
+		split = len(t.buf)
+	}
+	t.buf = append(t.buf[:0], t.buf[split:]...)
+
+	return split, nil
+}
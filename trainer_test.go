@@ -0,0 +1,175 @@
+package markov
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTrainer(t *testing.T) {
+	t.Parallel()
+
+	var documents = []string{smoothingText, "Ça va? Très bien, merci. Et toi?", "I am batman"}
+
+	var tests = []struct {
+		name string
+		opts []Option
+	}{
+		{name: "words"},
+		{name: "punctuation", opts: []Option{WithTokenizer(PunctuationTokenizer{})}},
+		{name: "sentence boundaries", opts: []Option{WithSentenceBoundaries()}},
+		{name: "backoff", opts: []Option{WithBackoff(KatzBackoff())}},
+		{name: "small max token size", opts: []Option{WithMaxTokenSize(6, SplitOversized)}},
+		{name: "runes", opts: []Option{WithTokenizer(RuneTokenizer{})}},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var want, _ = NewNGramChain(3, tt.opts...)
+			for _, document := range documents {
+				want.ProcessText(strings.NewReader(document))
+			}
+
+			// the chunks split tokens and runes anywhere
+			for _, size := range []int{1, 2, 3, 7, 64, 1 << 20} {
+				var chain, _ = NewNGramChain(3, tt.opts...)
+				var trainer = chain.NewTrainer()
+
+				for _, document := range documents {
+					for i := 0; i < len(document); i += size {
+						var chunk = document[i:min(i+size, len(document))]
+						if n, err := trainer.Write([]byte(chunk)); n != len(chunk) || err != nil {
+							t.Fatalf("got %v %v, want %v", n, err, len(chunk))
+						}
+					}
+
+					if err := trainer.Flush(); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+
+				if err := trainer.Close(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+					t.Errorf("chunks of %d: got %v, want %v", size, entries, storeEntries(want.chain))
+				}
+
+				if seeds := seedTokens(chain.chain); !reflect.DeepEqual(seeds, seedTokens(want.chain)) {
+					t.Errorf("chunks of %d: got %v, want %v", size, seeds, seedTokens(want.chain))
+				}
+			}
+		})
+	}
+}
+
+func TestTrainer_errors(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2, WithMaxTokenSize(8, FailOversized))
+	var trainer = chain.NewTrainer()
+
+	trainer.Write([]byte("I am super"))
+	var n, err = trainer.Write([]byte("califragilistic batman"))
+
+	var wantErr = &TextError{Offset: 5, Err: bufio.ErrTooLong}
+	if n != 0 || !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got %v %v, want %v %v", n, err, 0, wantErr)
+	}
+
+	// the error stops the trainer
+	if _, err := trainer.Write([]byte("I am batman")); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("got %v, want %v", err, bufio.ErrTooLong)
+	}
+
+	if err := trainer.Close(); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("got %v, want %v", err, bufio.ErrTooLong)
+	}
+
+	if _, err := trainer.Write([]byte("I am batman")); err != ErrTrainerClosed {
+		t.Errorf("got %v, want %v", err, ErrTrainerClosed)
+	}
+
+	var wantEntries = []testEntry{{prefix: []string{"I"}, candidates: []testCandidate{{"am", 1}}}}
+	if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+}
+
+func TestTrainer_ReadFrom(t *testing.T) {
+	t.Parallel()
+
+	var text = strings.Repeat(smoothingText+" ", 2000)
+
+	var want, _ = NewNGramChain(3)
+	want.ProcessText(strings.NewReader(text))
+
+	var tests = []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{name: "reader", reader: func() io.Reader { return strings.NewReader(text) }},
+		{name: "one byte at a time", reader: func() io.Reader { return iotest.OneByteReader(strings.NewReader(text)) }},
+		{name: "half of the bytes at a time", reader: func() io.Reader { return iotest.HalfReader(strings.NewReader(text)) }},
+		{name: "data along with EOF", reader: func() io.Reader { return iotest.DataErrReader(strings.NewReader(text)) }},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, _ = NewNGramChain(3)
+			var trainer = chain.NewTrainer()
+
+			// io.Copy reads with ReadFrom, unless the reader implements
+			// io.WriterTo, which writes with Write
+			if n, err := io.Copy(trainer, tt.reader()); n != int64(len(text)) || err != nil {
+				t.Fatalf("got %v %v, want %v", n, err, len(text))
+			}
+
+			if err := trainer.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, storeEntries(want.chain)) {
+				t.Errorf("got %v, want %v", len(entries), len(storeEntries(want.chain)))
+			}
+		})
+	}
+}
+
+func TestTrainer_ReadFrom_error(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2)
+	var trainer = chain.NewTrainer()
+
+	var reader = &failingReader{text: strings.NewReader("I am bat"), err: io.ErrUnexpectedEOF}
+	var n, err = trainer.ReadFrom(reader)
+
+	var wantErr = &TextError{Offset: 5, Err: io.ErrUnexpectedEOF}
+	if n != 8 || !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("got %v %v, want %v %v", n, err, 8, wantErr)
+	}
+
+	// the trainer carries on after read errors
+	trainer.Write([]byte("man"))
+	trainer.Close()
+
+	var wantEntries = []testEntry{
+		{prefix: []string{"I"}, candidates: []testCandidate{{"am", 1}}},
+		{prefix: []string{"am"}, candidates: []testCandidate{{"batman", 1}}},
+	}
+	if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("got %v, want %v", entries, wantEntries)
+	}
+}
@@ -0,0 +1,44 @@
+package markov
+
+// Vocabulary is a read only view of the symbols known by a chain. Every symbol
+// is interned as a uint32 ID the first time it's processed, and the chain only
+// stores the IDs, so every symbol is kept once in memory however many ngrams
+// it's part of. The view stays up to date as the chain processes more input
+// and it's safe for concurrent use.
+//
+// For NGramChain, StartToken and EndToken are always known, with the IDs 0 and
+// 1.
+type Vocabulary[T comparable] struct {
+	symbols *symbolTable[T]
+}
+
+// ID returns the ID of the symbol, or false if the chain has never seen it
+func (v Vocabulary[T]) ID(symbol T) (uint32, bool) {
+	return v.symbols.id(symbol)
+}
+
+// Symbol returns the symbol with the given ID, or false if no symbol has it
+func (v Vocabulary[T]) Symbol(id uint32) (T, bool) {
+	return v.symbols.symbol(id)
+}
+
+// Len returns the number of symbols known
+func (v Vocabulary[T]) Len() int {
+	return v.symbols.len()
+}
+
+// Symbols returns every symbol known, sorted by ID
+func (v Vocabulary[T]) Symbols() []T {
+	return v.symbols.all()
+}
+
+// Vocab returns the vocabulary of the chain
+func (c *Chain[T]) Vocab() Vocabulary[T] {
+	return Vocabulary[T]{symbols: c.symbols}
+}
+
+// Vocab returns the vocabulary of the chain, made of the tokens it has
+// processed after case folding
+func (c *NGramChain) Vocab() Vocabulary[string] {
+	return c.chain.Vocab()
+}
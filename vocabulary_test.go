@@ -0,0 +1,64 @@
+package markov
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNGramChain_Vocab(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(2, WithCaseFolding())
+	var vocab = chain.Vocab()
+	chain.ProcessText(strings.NewReader("I am batman. i AM groot."))
+
+	// the view follows the chain
+	var wantSymbols = []string{StartToken, EndToken, "i", "am", "batman.", "groot."}
+	if symbols := vocab.Symbols(); !reflect.DeepEqual(symbols, wantSymbols) {
+		t.Errorf("got %v, want %v", symbols, wantSymbols)
+	}
+
+	if vocab.Len() != len(wantSymbols) {
+		t.Errorf("got %v, want %v", vocab.Len(), len(wantSymbols))
+	}
+
+	for wantID, wantSymbol := range wantSymbols {
+		if id, exists := vocab.ID(wantSymbol); !exists || id != uint32(wantID) {
+			t.Errorf("got %v %v, want %v %v", id, exists, wantID, true)
+		}
+
+		if symbol, exists := vocab.Symbol(uint32(wantID)); !exists || symbol != wantSymbol {
+			t.Errorf("got %v %v, want %v %v", symbol, exists, wantSymbol, true)
+		}
+	}
+
+	if id, exists := vocab.ID("I"); exists {
+		t.Errorf("got %v %v, want %v %v", id, exists, 0, false)
+	}
+
+	if symbol, exists := vocab.Symbol(uint32(len(wantSymbols))); exists {
+		t.Errorf("got %v %v, want %v %v", symbol, exists, "", false)
+	}
+}
+
+func TestChain_Vocab(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewChain[int](2)
+	chain.Add([]int{0, 7, 0, 9})
+
+	// the reserved IDs have no symbol, even if the zero value is known
+	var vocab = chain.Vocab()
+	if symbol, exists := vocab.Symbol(startID); exists {
+		t.Errorf("got %v %v, want %v %v", symbol, exists, 0, false)
+	}
+
+	if symbols := vocab.Symbols(); !reflect.DeepEqual(symbols, []int{0, 7, 9}) {
+		t.Errorf("got %v, want %v", symbols, []int{0, 7, 9})
+	}
+
+	if id, exists := vocab.ID(0); !exists || id != firstSymbolID {
+		t.Errorf("got %v %v, want %v %v", id, exists, firstSymbolID, true)
+	}
+}
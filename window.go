@@ -0,0 +1,219 @@
+package markov
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// StartToken pads the beginning of every sequence processed by a bounded
+	// NGramChain, so generation can start at the beginning of a sequence
+	StartToken = "<s>"
+	// EndToken closes every sequence processed by a bounded NGramChain, so
+	// generation can stop at the end of a sequence. Tokenizers of bounded
+	// chains can emit it to signal the end of a sequence.
+	EndToken = "</s>"
+)
+
+// window keeps track of the last symbol IDs seen while processing a sequence,
+// sliding over them to build the ngrams fed to the chain
+type window[T comparable] struct {
+	chain *Chain[T]
+	ngram []uint32
+
+	// intern returns the ID of every symbol pushed and process receives every
+	// ngram. They default to the chain ones, but they can be replaced to feed
+	// the ngrams somewhere else, like a scorer
+	intern  func(symbol T) uint32
+	process func(ngram []uint32) error
+
+	// batch holds the ngrams of chains with a striped store, one after the
+	// other, and ends where every one of them ends. They're added in batches
+	// so the goroutines processing input don't contend for the lock
+	batch []uint32
+	ends  []int
+
+	// added is the number of ngrams added to the chain so far, when they're
+	// not processed somewhere else
+	added int
+}
+
+// batchSize is the number of ngrams a window of a chain with a striped store
+// adds at once
+const batchSize = 1024
+
+// newWindow returns an empty window for the chain. For bounded chains, the
+// window starts with the n-1 start boundaries padding.
+func (c *Chain[T]) newWindow() *window[T] {
+	var w = &window[T]{
+		chain:  c,
+		ngram:  make([]uint32, 0, c.n),
+		intern: c.symbols.intern,
+	}
+
+	w.process = w.add
+	if c.striped() {
+		w.process = w.buffer
+	}
+	w.reset()
+
+	return w
+}
+
+// push adds the symbol to the window, processing the resulting ngram once
+// there's enough symbols. Chains with backoff also process the shorter ngrams
+// at the beginning of a sequence, so they learn every order.
+func (w *window[T]) push(symbol T) error {
+	w.ngram = append(w.ngram, w.intern(symbol))
+	if len(w.ngram) < int(w.chain.n) {
+		if w.chain.backoff == nil {
+			return nil
+		}
+
+		return w.process(w.ngram)
+	}
+
+	if err := w.process(w.ngram); err != nil {
+		return err
+	}
+
+	// slide the window, keeping the last n-1 symbols
+	copy(w.ngram, w.ngram[1:])
+	w.ngram = w.ngram[:len(w.ngram)-1]
+
+	return nil
+}
+
+// end closes the current sequence of a bounded chain with an end boundary and
+// resets the window for the next one. Empty sequences are ignored.
+func (w *window[T]) end() error {
+	if w.empty() {
+		return nil
+	}
+
+	w.ngram = append(w.ngram, endID)
+	if err := w.process(w.ngram); err != nil {
+		return err
+	}
+
+	w.reset()
+
+	return nil
+}
+
+// close ends the last sequence, if the chain is bounded, and adds the ngrams
+// left in the batch. It must be called once the input is over.
+func (w *window[T]) close() error {
+	if w.chain.bounded {
+		if err := w.end(); err != nil {
+			return err
+		}
+	}
+
+	return w.flush()
+}
+
+// add adds the ngram to the chain
+func (w *window[T]) add(ngram []uint32) error {
+	if err := w.chain.processNgram(ngram); err != nil {
+		return err
+	}
+	w.added++
+
+	return nil
+}
+
+// buffer adds the ngram to the batch, adding the batch to the chain once it's
+// full
+func (w *window[T]) buffer(ngram []uint32) error {
+	w.batch = append(w.batch, ngram...)
+	w.ends = append(w.ends, len(w.batch))
+
+	if len(w.ends) < batchSize {
+		return nil
+	}
+
+	return w.flush()
+}
+
+// flush adds the ngrams of the batch to the chain, sharing the write lock with
+// any other goroutine doing the same. The input is only read while the lock is
+// not held
+func (w *window[T]) flush() error {
+	if len(w.ends) == 0 {
+		return nil
+	}
+
+	var chain = w.chain
+	chain.lock.lockShared()
+	defer chain.lock.unlockShared()
+
+	var start = 0
+	for _, end := range w.ends {
+		if err := chain.addNgram(w.batch[start:end], 1); err != nil {
+			return err
+		}
+		w.added++
+		start = end
+	}
+
+	w.batch = w.batch[:0]
+	w.ends = w.ends[:0]
+
+	return nil
+}
+
+// reset clears the window, adding the start boundaries padding for bounded
+// chains
+func (w *window[T]) reset() {
+	w.ngram = w.ngram[:0]
+	if !w.chain.bounded {
+		return
+	}
+
+	for i := uint(1); i < w.chain.n; i++ {
+		w.ngram = append(w.ngram, startID)
+	}
+}
+
+// empty returns true if no symbols have been pushed to the bounded window
+// since the last reset
+func (w *window[T]) empty() bool {
+	return len(w.ngram) == int(w.chain.n)-1 && w.ngram[len(w.ngram)-1] == startID
+}
+
+// startKey returns the store key for the beginning of a sequence
+func (c *Chain[T]) startKey() string {
+	var ids = make([]uint32, c.n-1)
+	for i := range ids {
+		ids[i] = startID
+	}
+
+	return packKey(ids)
+}
+
+// sentenceTerminals are the runes ending a sentence, including the ones used
+// by non-Latin scripts
+var sentenceTerminals = map[rune]bool{
+	'.': true, '!': true, '?': true, '…': true,
+	'。': true, '！': true, '？': true, '؟': true, '।': true, '։': true,
+}
+
+// isSentenceEnd returns true if the token ends with a sentence terminal,
+// ignoring any closing quotes or brackets after it like in `batman."`
+func isSentenceEnd(token string) bool {
+	for len(token) > 0 {
+		var r, width = utf8.DecodeLastRuneInString(token)
+		if sentenceTerminals[r] {
+			return true
+		}
+
+		if !unicode.In(r, unicode.Pe, unicode.Pf) && r != '"' && r != '\'' {
+			return false
+		}
+
+		token = token[:len(token)-width]
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package markov
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSentenceBoundaries_ProcessText(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		opts []Option
+		text string
+
+		wantEntries []testEntry
+	}{
+		{
+			name: "ok - sentences",
+			text: "i am batman. i am groot! yes",
+			wantEntries: []testEntry{
+				{prefix: []string{StartToken, StartToken}, candidates: []testCandidate{{"i", 2}, {"yes", 1}}},
+				{prefix: []string{StartToken, "i"}, candidates: []testCandidate{{"am", 2}}},
+				{prefix: []string{"i", "am"}, candidates: []testCandidate{{"batman.", 1}, {"groot!", 1}}},
+				{prefix: []string{"am", "batman."}, candidates: []testCandidate{{EndToken, 1}}},
+				{prefix: []string{"am", "groot!"}, candidates: []testCandidate{{EndToken, 1}}},
+				{prefix: []string{StartToken, "yes"}, candidates: []testCandidate{{EndToken, 1}}},
+			},
+		},
+		{
+			name: "ok - non latin",
+			opts: []Option{WithTokenizer(RuneTokenizer{})},
+			text: "猫だ。犬",
+			wantEntries: []testEntry{
+				{prefix: []string{StartToken, StartToken}, candidates: []testCandidate{{"猫", 1}, {"犬", 1}}},
+				{prefix: []string{StartToken, "猫"}, candidates: []testCandidate{{"だ", 1}}},
+				{prefix: []string{"猫", "だ"}, candidates: []testCandidate{{"。", 1}}},
+				{prefix: []string{"だ", "。"}, candidates: []testCandidate{{EndToken, 1}}},
+				{prefix: []string{StartToken, "犬"}, candidates: []testCandidate{{EndToken, 1}}},
+			},
+		},
+		{
+			name: "ok - punctuation tokens",
+			opts: []Option{WithTokenizer(PunctuationTokenizer{})},
+			text: "Hi. (Bye)",
+			wantEntries: []testEntry{
+				{prefix: []string{StartToken, StartToken}, candidates: []testCandidate{{"Hi", 1}, {"(", 1}}},
+				{prefix: []string{StartToken, "Hi"}, candidates: []testCandidate{{".", 1}}},
+				{prefix: []string{"Hi", "."}, candidates: []testCandidate{{EndToken, 1}}},
+				{prefix: []string{StartToken, "("}, candidates: []testCandidate{{"Bye", 1}}},
+				{prefix: []string{"(", "Bye"}, candidates: []testCandidate{{")", 1}}},
+				{prefix: []string{"Bye", ")"}, candidates: []testCandidate{{EndToken, 1}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var chain, err = NewNGramChain(3, append(tt.opts, WithSentenceBoundaries())...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := chain.ProcessText(strings.NewReader(tt.text)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if entries := storeEntries(chain.chain); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("got %v, want %v", entries, tt.wantEntries)
+			}
+
+			if len(chain.chain.seeds) != 0 {
+				t.Errorf("got %v, want no seeds", seedTokens(chain.chain))
+			}
+		})
+	}
+}
+
+func TestSentenceBoundaries_GenerateRandomText(t *testing.T) {
+	t.Parallel()
+
+	var chain, _ = NewNGramChain(3, WithSentenceBoundaries())
+	chain.ProcessText(strings.NewReader("i am batman. i am groot."))
+	chain.chain.randFunc = func(int) int { return 0 }
+
+	// lower case sentences are generated from their beginning to their end
+	var text = chain.GenerateRandomText(100)
+	if text != "i am batman." {
+		t.Errorf("got %q, want %q", text, "i am batman.")
+	}
+}
+
+func TestIsSentenceEnd(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		token string
+		want  bool
+	}{
+		{token: "batman.", want: true},
+		{token: "groot?!", want: true},
+		{token: `father."`, want: true},
+		{token: "(yes!)", want: true},
+		{token: "猫だ。", want: true},
+		{token: "batman", want: false},
+		{token: "batman,", want: false},
+		{token: `"`, want: false},
+		{token: "", want: false},
+	}
+
+	for _, tt := range tests {
+		var tt = tt
+		t.Run(tt.token, func(t *testing.T) {
+			t.Parallel()
+
+			if got := isSentenceEnd(tt.token); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}